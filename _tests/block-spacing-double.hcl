@@ -0,0 +1,22 @@
+name = "foo"
+
+
+tags = [
+  "a",
+  "b",
+]
+
+
+widget "a" {
+  size = 1
+}
+
+
+widget "b" {
+  size = 2
+}
+
+
+nested {
+  x = "y"
+}
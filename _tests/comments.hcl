@@ -0,0 +1,23 @@
+# The deployment region
+region = "us-east-1"
+
+# Availability zones
+# in preference order
+zones = [
+  "a",
+  "b",
+]
+
+# Widgets, keyed by name
+widget "a" {
+  size = 1
+}
+
+widget "b" {
+  size = 2
+}
+
+nested {
+  # Size: in bytes
+  size = 0
+}
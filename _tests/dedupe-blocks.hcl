@@ -0,0 +1,11 @@
+widget "a" {
+  size = 1
+}
+
+widget "b" {
+  size = 2
+}
+
+widget "a" {
+  size = 3
+}
@@ -0,0 +1,18 @@
+name = "web"
+
+metadata {
+  labels {
+    app = "web"
+  }
+
+  namespace = "default"
+}
+
+spec {
+  replicas = 3
+
+  template {
+    # The container image
+    image = "nginx"
+  }
+}
@@ -0,0 +1,11 @@
+widget "foo" {
+  size = 1
+}
+
+widget "bar" {
+  size = 2
+}
+
+widget "baz" {
+  size = 3
+}
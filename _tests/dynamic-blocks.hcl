@@ -0,0 +1,19 @@
+name = "web"
+
+dynamic "ingress" {
+  for_each = var.ingress_rules
+
+  content {
+    from_port = ingress.value.port
+    protocol  = "tcp"
+  }
+}
+
+dynamic "egress" {
+  for_each = var.egress_rules
+
+  content {
+    from_port = egress.value.port
+    protocol  = "udp"
+  }
+}
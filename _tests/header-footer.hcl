@@ -0,0 +1,23 @@
+# This file is generated.
+# Do not edit.
+
+name = "foo"
+
+tags = [
+  "a",
+  "b",
+]
+
+widget "a" {
+  size = 1
+}
+
+widget "b" {
+  size = 2
+}
+
+nested {
+  x = "y"
+}
+
+# end of file
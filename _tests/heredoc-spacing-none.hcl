@@ -0,0 +1,17 @@
+script = <<EOF
+set -e
+
+name = ${var.name}
+
+
+exit 0
+EOF
+steps = [<<EOF
+make
+
+make test
+EOF
+]
+widget "a" {
+  size = 1
+}
@@ -0,0 +1,18 @@
+script = <<EOF1
+echo ${var.greeting}
+EOF
+exit 0
+EOF1
+
+name = "${var.name}"
+
+steps = [
+  <<EOF
+make
+EOF
+  ,
+  <<EOF
+make test
+EOF
+  ,
+]
@@ -0,0 +1,14 @@
+region = "us-east-1" # primary
+
+zones = [
+  "a",
+  "b",
+] # preferred first
+
+widget "a" {
+  size = 1
+}
+
+nested {
+  x = "y"
+}
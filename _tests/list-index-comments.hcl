@@ -0,0 +1,5 @@
+zones = [
+  "a", # 0
+  "b", # 1
+  "c", # 2
+]
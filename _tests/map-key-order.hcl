@@ -0,0 +1,11 @@
+labels {
+  name    = "web"
+  version = "1.2"
+  app     = "api"
+  team    = "core"
+}
+
+ports {
+  name = 1
+  http = 80
+}
@@ -0,0 +1,13 @@
+widget "y" {
+  size = 1
+}
+
+widget "x" {
+  size = 2
+}
+
+c = 3
+
+b = 2
+
+a = 1
@@ -0,0 +1,15 @@
+widget {
+  a {
+    Bar = "x"
+  }
+
+  c {
+    Bar = "y"
+  }
+}
+
+keyed {
+  a "x" {
+    size = 1
+  }
+}
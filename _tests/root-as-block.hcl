@@ -0,0 +1,23 @@
+config {
+  name  = "Ol' McDonald's Farm"
+  owned = true
+
+  location = [
+    12.34,
+    -5.67,
+  ]
+
+  acres = 0
+
+  farmer {
+    Age = 65
+  }
+
+  animal "cow" {
+    says = "moo"
+  }
+
+  buildings {
+    Barn = "456 Digits Drive"
+  }
+}
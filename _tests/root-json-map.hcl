@@ -0,0 +1,15 @@
+enabled = true
+
+limits {
+  cpu    = 0.5
+  memory = "512Mi"
+}
+
+region = "us-east-1"
+
+replicas = 3
+
+tags = [
+  "a",
+  "b",
+]
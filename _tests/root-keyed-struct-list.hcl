@@ -0,0 +1,7 @@
+"foo" {
+  size = 1
+}
+
+"bar" {
+  size = 2
+}
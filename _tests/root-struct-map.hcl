@@ -0,0 +1,7 @@
+bar {
+  Bar = "buzz"
+}
+
+foo {
+  Bar = "fizz"
+}
@@ -0,0 +1,10 @@
+settings {
+  level = 1
+  mode  = "fast"
+}
+
+logging "stdout" {
+  size = 2
+}
+
+after = "done"
@@ -2,6 +2,7 @@ package hclencoder
 
 import (
 	"log"
+	"os"
 
 	"fmt"
 )
@@ -117,3 +118,34 @@ func Example() {
 	// }
 	//
 }
+
+func ExampleEncoder() {
+	type Server struct {
+		Name string `hcl:",key"`
+		Port int    `hcl:"port"`
+	}
+
+	type Config struct {
+		Servers []Server `hcl:"server"`
+	}
+
+	input := Config{
+		Servers: []Server{
+			{Name: "web", Port: 80},
+			{Name: "api", Port: 8080},
+		},
+	}
+
+	if err := NewEncoder(os.Stdout).Encode(input); err != nil {
+		log.Fatal("unable to encode: ", err)
+	}
+
+	// Output:
+	// server "web" {
+	//   port = 80
+	// }
+	//
+	// server "api" {
+	//   port = 8080
+	// }
+}
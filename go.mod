@@ -1,8 +1,14 @@
 module github.com/rodaine/hclencoder
 
-go 1.14
+go 1.20
 
 require (
 	github.com/hashicorp/hcl v1.0.0
 	github.com/stretchr/testify v1.6.1
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
@@ -2,19 +2,520 @@ package hclencoder
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 
+	"github.com/hashicorp/hcl"
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/hashicorp/hcl/hcl/printer"
 )
 
-// Encode converts any supported type into the corresponding HCL format
-func Encode(in interface{}) ([]byte, error) {
-	node, _, err := encode(reflect.ValueOf(in))
+// Encoder writes the HCL representation of Go values to an output stream.
+type Encoder struct {
+	w io.Writer
+
+	// DrainChannels permits encoding channel values by receiving from them
+	// until they are closed, encoding the received values as a list (or
+	// block list). The channel is consumed in the process, and must be
+	// closed, as encoding otherwise blocks until MaxDrainCount values are
+	// received. By default, channels cannot be encoded.
+	DrainChannels bool
+
+	// BlockSpacing is the number of blank lines emitted between top-level
+	// attributes and blocks. NewEncoder defaults this to 1.
+	BlockSpacing int
+
+	// Unformatted skips the HCL printer, writing each item on its own line
+	// without indentation, alignment, or blank lines. This is faster than
+	// formatting the output, and BlockSpacing, Indent, and SingleSpaceEquals
+	// have no effect.
+	Unformatted bool
+
+	// SingleSpaceEquals collapses the padding the printer adds to align the
+	// equals signs of adjacent attributes to a single space.
+	SingleSpaceEquals bool
+
+	// Indent is the number of spaces used to indent the contents of blocks
+	// and multi-line lists. Values less than 1 use the default of 2.
+	Indent int
+
+	// TagName and MetaTagName are the struct field tags consulted in place of
+	// HCLTagName and HCLETagName, respectively, when not empty. This allows
+	// the same structs to carry tags for another HCL decoder.
+	TagName     string
+	MetaTagName string
+
+	// DedupeBlocks drops blocks encoded from a slice that are identical to an
+	// earlier block from the same slice, including their labels.
+	DedupeBlocks bool
+
+	// RequireLabels fails the encoding of a block whose key field is an
+	// empty string, which would otherwise produce an empty label (eg,
+	// `animal "" {}`). Key fields tagged with OmitEmptyTag instead omit the
+	// entire block when empty, regardless of this setting.
+	RequireLabels bool
+
+	// StructSlicesAsBlocks emits every slice of structs, maps, or interfaces
+	// as repeated blocks, as if the field were tagged with BlocksTag. Key
+	// fields of the elements still become the labels of their blocks, and
+	// elements without key fields produce unlabeled blocks. A slice holding
+	// an element that is not a struct or map remains a list, as do fields
+	// tagged with DynamicTag.
+	StructSlicesAsBlocks bool
+
+	// MapKeySort, if set, orders the keys of each map in place before they
+	// are encoded, replacing the default alphabetical order. The blocks of a
+	// map entry holding a slice of keyed structs keep their slice order.
+	MapKeySort func(keys []string)
+
+	// MapKeyOrder specifies the order in which the keys of maps of the given
+	// types are encoded, taking precedence over MapKeySort. Keys missing from
+	// the order follow in alphabetical order.
+	MapKeyOrder map[reflect.Type][]string
+
+	// RootAsBlock, if set, wraps the encoded struct or map in a block of this
+	// type instead of emitting its fields at the top level (eg, "config"
+	// produces `config { ... }`). Key fields of the root struct become the
+	// labels of the block.
+	RootAsBlock string
+
+	// JSONMapKeys permits maps with struct keys, using the JSON encoding of
+	// each key as its HCL key (eg, `"{\"x\":1,\"y\":2}" = true`).
+	JSONMapKeys bool
+
+	// EmitNull encodes nil elements of primitive lists as null, preserving the
+	// position of the other elements. By default, nil elements are dropped.
+	EmitNull bool
+
+	// ListInlineMaxLen is the maximum number of elements of a primitive list
+	// printed on a single line (eg, `ports = [80, 443]`). Longer lists are
+	// printed with one element per line. By default, only lists with a single
+	// element are printed on one line.
+	ListInlineMaxLen int
+
+	// TruncateLen, if positive, is the maximum number of elements of each
+	// primitive list and map encoded, for previewing large values. The last
+	// element kept is followed by a comment with the number of elements
+	// dropped (eg, `# ... and 95 more`). If that element is itself a list or
+	// object, the comment follows the attribute holding the list instead.
+	TruncateLen int
+
+	// ListIndexComments emits a trailing comment with the index of each
+	// element of a primitive list (eg, `"a", # 0`).
+	ListIndexComments bool
+
+	// OnBlock, if set, is called for each block in the output after the value
+	// is encoded but before it is printed. The path is the dot-separated keys
+	// of the block and its parents (eg, "animal.cow"). The block may be
+	// modified in place, and is not reused by the Encoder once Encode
+	// returns, so it may be kept.
+	OnBlock func(path string, block *ast.ObjectItem)
+
+	// UseJSONMarshaler encodes values implementing json.Marshaler, but not
+	// encoding.TextMarshaler, as the HCL equivalent of the JSON they produce
+	// (eg, a JSON object becomes an HCL object).
+	UseJSONMarshaler bool
+
+	// SortFields emits the attributes and blocks of each struct sorted by
+	// name instead of in the order of their fields, so that reordering the
+	// fields does not change the output. Repeated blocks keep their order,
+	// key fields remain in the order of their fields as they are positional,
+	// and fields are only sorted within their section.
+	SortFields bool
+
+	// MultiError continues encoding the remaining fields of a struct after a
+	// field fails to encode, so that every error is returned at once, each
+	// with the path of its field. The errors are combined with errors.Join,
+	// so that errors.Is and errors.As match each of them. Nothing is written
+	// if any field fails.
+	MultiError bool
+
+	// SkipUnsupported omits values that cannot be represented in HCL, such
+	// as functions, complex numbers, and unsafe.Pointer values, as if they
+	// were nil. By default, they produce an error naming their path.
+	SkipUnsupported bool
+
+	// RedactedText replaces each value of the fields tagged with SensitiveTag.
+	// Defaults to DefaultRedactedText.
+	RedactedText string
+
+	// OmitSensitive omits the fields tagged with SensitiveTag instead of
+	// redacting their values.
+	OmitSensitive bool
+
+	// Formatter, if set, is applied to the printed HCL before the Header and
+	// Footer are added, such as to lint or rewrite it. Combined with
+	// Unformatted, it replaces the HCL printer entirely. An error returned by
+	// Formatter is returned as is.
+	Formatter func([]byte) ([]byte, error)
+
+	// StringEscaper, if set, escapes the contents of each quoted string value
+	// in place of EscapeString (eg, to leave tabs unescaped). It is also
+	// responsible for preserving or escaping interpolation sequences, except
+	// for values tagged with LiteralTag, whose sequences are escaped before
+	// the escaper is applied to the text between them. Keys, labels, and
+	// heredocs are not affected.
+	StringEscaper func(string) string
+
+	// CommentStyle is the prefix of every comment emitted by the encoder.
+	// Defaults to HashComment.
+	CommentStyle CommentStyle
+
+	// Header and Footer are comment lines emitted before and after the
+	// encoded HCL, respectively, separated from it by a blank line (eg,
+	// "This file is generated. Do not edit.").
+	Header []string
+	Footer []string
+
+	// path and visiting track the location of the value being encoded and the
+	// pointers, maps, and slices currently being encoded, to detect cycles.
+	path     []string
+	visiting map[visitKey]int
+
+	// errs holds the errors of each field collected with MultiError.
+	errs []error
+
+	// truncated holds the number of elements dropped from each list truncated
+	// by TruncateLen whose last element cannot carry a comment.
+	truncated map[*ast.ListType]int
+
+	// redacting is set while encoding the value of a sensitive field.
+	redacting bool
+
+	// file, if set, restricts the fields of the root struct to those
+	// emitted into the named EncodeMultiFile output.
+	file *string
+}
+
+// tagName returns the struct field tag holding the HCL decoder values.
+func (e *Encoder) tagName() string {
+	if e.TagName != "" {
+		return e.TagName
+	}
+	return HCLTagName
+}
+
+// stringEscaper returns the function escaping the contents of quoted strings.
+func (e *Encoder) stringEscaper() func(string) string {
+	if e.StringEscaper != nil {
+		return e.StringEscaper
+	}
+	return EscapeString
+}
+
+// DefaultRedactedText replaces the values of sensitive fields unless
+// Encoder.RedactedText is set.
+const DefaultRedactedText = "***REDACTED***"
+
+// redactedText returns the text replacing the values of sensitive fields.
+func (e *Encoder) redactedText() string {
+	if e.RedactedText != "" {
+		return e.RedactedText
+	}
+	return DefaultRedactedText
+}
+
+// metaTagName returns the struct field tag holding the hcle values.
+func (e *Encoder) metaTagName() string {
+	if e.MetaTagName != "" {
+		return e.MetaTagName
+	}
+	return HCLETagName
+}
+
+// Expr is an HCL expression, such as a variable reference or function call,
+// that is emitted verbatim instead of as a quoted string. It may be used as a
+// field, map value, or list element. The expression is not validated.
+type Expr string
+
+// RawExpression is an alias of Expr. Like Expr, its contents are emitted
+// verbatim regardless of any formatting tags on the field.
+type RawExpression = Expr
+
+// indent returns the number of spaces used for each level of indentation.
+func (e *Encoder) indent() int {
+	if e.Indent > 0 {
+		return e.Indent
+	}
+	return 2
+}
+
+// commentStyle returns the prefix of emitted comments.
+func (e *Encoder) commentStyle() CommentStyle {
+	if e.CommentStyle != "" {
+		return e.CommentStyle
+	}
+	return HashComment
+}
+
+// CommentStyle is the prefix of a single-line HCL comment.
+type CommentStyle string
+
+const (
+	// HashComment prefixes comments with a hash (eg, `# comment`).
+	HashComment CommentStyle = "#"
+
+	// SlashComment prefixes comments with double slashes (eg, `// comment`).
+	SlashComment CommentStyle = "//"
+)
+
+// An Option configures an Encoder.
+type Option func(*Encoder)
+
+// WithIndent sets the number of spaces used for each level of indentation.
+func WithIndent(n int) Option {
+	return func(e *Encoder) { e.Indent = n }
+}
+
+// WithCommentStyle sets the prefix of every comment emitted by the encoder.
+func WithCommentStyle(style CommentStyle) Option {
+	return func(e *Encoder) { e.CommentStyle = style }
+}
+
+// WithJSONMapKeys permits maps with struct keys, encoding each key as JSON.
+func WithJSONMapKeys() Option {
+	return func(e *Encoder) { e.JSONMapKeys = true }
+}
+
+// WithRequireLabels fails the encoding of blocks with an empty key field.
+func WithRequireLabels() Option {
+	return func(e *Encoder) { e.RequireLabels = true }
+}
+
+// WithStructSlicesAsBlocks emits slices of structs as repeated blocks even
+// if their fields are not tagged with BlocksTag.
+func WithStructSlicesAsBlocks() Option {
+	return func(e *Encoder) { e.StructSlicesAsBlocks = true }
+}
+
+// WithTruncation limits primitive lists and maps to n elements, noting the
+// number of elements dropped in a comment.
+func WithTruncation(n int) Option {
+	return func(e *Encoder) { e.TruncateLen = n }
+}
+
+// WithJSONMarshaler encodes values implementing only json.Marshaler as the HCL
+// equivalent of the JSON they produce.
+func WithJSONMarshaler() Option {
+	return func(e *Encoder) { e.UseJSONMarshaler = true }
+}
+
+// WithSortedFields emits the attributes and blocks of each struct sorted by
+// name.
+func WithSortedFields() Option {
+	return func(e *Encoder) { e.SortFields = true }
+}
+
+// WithMultiError collects the errors of every field that fails to encode,
+// instead of stopping at the first.
+func WithMultiError() Option {
+	return func(e *Encoder) { e.MultiError = true }
+}
+
+// WithSkipUnsupported omits values that cannot be represented in HCL instead
+// of failing to encode them.
+func WithSkipUnsupported() Option {
+	return func(e *Encoder) { e.SkipUnsupported = true }
+}
+
+// WithOmitSensitive omits the fields tagged with SensitiveTag instead of
+// redacting their values.
+func WithOmitSensitive() Option {
+	return func(e *Encoder) { e.OmitSensitive = true }
+}
+
+// WithFormatter applies fn to the printed HCL before it is written.
+func WithFormatter(fn func([]byte) ([]byte, error)) Option {
+	return func(e *Encoder) { e.Formatter = fn }
+}
+
+// WithStringEscaper escapes the contents of quoted string values with fn
+// instead of EscapeString.
+func WithStringEscaper(fn func(string) string) Option {
+	return func(e *Encoder) { e.StringEscaper = fn }
+}
+
+// WithTagName sets the struct field tag consulted in place of HCLTagName.
+func WithTagName(name string) Option {
+	return func(e *Encoder) { e.TagName = name }
+}
+
+// WithCompact removes the blank lines between top-level attributes and
+// blocks. It is equivalent to a BlockSpacing of 0.
+func WithCompact() Option {
+	return func(e *Encoder) { e.BlockSpacing = 0 }
+}
+
+// WithoutFormatting skips the HCL printer, writing the output unformatted.
+func WithoutFormatting() Option {
+	return func(e *Encoder) { e.Unformatted = true }
+}
+
+// WithEmitNull encodes nil elements of primitive lists as null instead of
+// dropping them.
+func WithEmitNull() Option {
+	return func(e *Encoder) { e.EmitNull = true }
+}
+
+// NewEncoder returns a new Encoder that writes to w, configured by opts.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	e := &Encoder{w: w, BlockSpacing: 1}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Encode converts any supported type into the corresponding HCL format. The
+// members of a root struct or map (eg, a map[string]interface{} produced by
+// json.Unmarshal) are emitted as top-level attributes and blocks. A slice of
+// keyed structs is emitted as a sequence of blocks, while other root values
+// such as primitives and lists are emitted as a bare expression. The output can
+// be customized with opts.
+func Encode(in interface{}, opts ...Option) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if err := NewEncoder(b, opts...).Encode(in); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MustEncode behaves like Encode, but panics if in cannot be encoded. It is
+// intended for test fixtures and values known to be valid.
+func MustEncode(in interface{}, opts ...Option) []byte {
+	b, err := Encode(in, opts...)
+	if err != nil {
+		panic(fmt.Errorf("hclencoder: %w", err))
+	}
+	return b
+}
+
+// EncodeToFile encodes in like Encode and writes the result to the file at
+// path with the permissions perm. The output is written to a temporary file in
+// the same directory, which is then renamed over path, so that path never
+// holds partial output. The filesystem is untouched if in cannot be encoded.
+func EncodeToFile(path string, in interface{}, perm os.FileMode, opts ...Option) error {
+	b, err := Encode(in, opts...)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// EncodeTyped behaves like Encode, but is restricted to values of type T.
+func EncodeTyped[T any](in T, opts ...Option) ([]byte, error) {
+	return Encode(in, opts...)
+}
+
+// MainFile is the name of the EncodeMultiFile output holding the fields
+// without a file tag.
+const MainFile = "main.hcl"
+
+// EncodeMultiFile converts a struct into multiple HCL documents, keyed by file
+// name. Fields tagged with a file name (eg, `hcl:"network,file=network.hcl"`)
+// are emitted into that file, while all other fields are emitted into
+// MainFile. The output can be customized with opts.
+func EncodeMultiFile(in interface{}, opts ...Option) (map[string][]byte, error) {
+	v, isNil := deref(reflect.ValueOf(in))
+	if isNil || v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode %T to multiple files, must be a struct", in)
+	}
+
+	b := &bytes.Buffer{}
+	e := NewEncoder(b, opts...)
+
+	metas, err := e.fieldMetas(v.Type())
 	if err != nil {
 		return nil, err
 	}
 
+	files := map[string][]byte{MainFile: nil}
+	for _, meta := range metas {
+		files[meta.fileName()] = nil
+	}
+
+	for name := range files {
+		name := name
+		e.file = &name
+		if err := e.Encode(v.Interface()); err != nil {
+			return nil, err
+		}
+		files[name] = append([]byte(nil), b.Bytes()...)
+		b.Reset()
+	}
+
+	return files, nil
+}
+
+// Decode parses the HCL in data and stores the result in the value pointed to
+// by v, using the upstream HCL decoder. It honors the same hcl struct tags as
+// Encode, so that the output of Encode can be decoded into the value it was
+// encoded from. Fields tagged with hcle:"omit" are never encoded and thus left
+// untouched.
+func Decode(data []byte, v interface{}) error {
+	return hcl.Unmarshal(data, v)
+}
+
+// Encode writes the HCL encoding of in to the stream. Any error returned by
+// the underlying io.Writer is returned as is.
+func (e *Encoder) Encode(in interface{}) error {
+	if style := e.commentStyle(); style != HashComment && style != SlashComment {
+		return fmt.Errorf("unsupported comment style %q", style)
+	}
+
+	e.errs, e.truncated = nil, nil
+	node, keys, err := e.encode(reflect.ValueOf(in))
+	if len(e.errs) > 0 {
+		if err != nil {
+			e.errs = append(e.errs, err)
+		}
+		err = e.errs[0]
+		if len(e.errs) > 1 {
+			err = errors.Join(e.errs...)
+		}
+		e.errs = nil
+	}
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return errors.New("cannot encode nil value to HCL")
+	}
+	if len(e.truncated) > 0 {
+		e.noteTruncations(node, nil)
+		e.truncated = nil
+	}
+	if e.RootAsBlock != "" {
+		if node, err = rootBlock(e.RootAsBlock, node, keys); err != nil {
+			return err
+		}
+	}
+
 	file := &ast.File{}
 	switch node := node.(type) {
 	case *ast.ObjectType:
@@ -23,13 +524,239 @@ func Encode(in interface{}) ([]byte, error) {
 		file.Node = node
 	}
 
-	if _, err = positionNodes(file, startingCursor, 2); err != nil {
-		return nil, err
+	if e.OnBlock != nil {
+		visitBlocks(file.Node, "", e.OnBlock)
 	}
 
+	out, err := e.print(file)
+	if err != nil {
+		return err
+	}
+	if e.Formatter != nil {
+		if out, err = e.Formatter(out); err != nil {
+			return err
+		}
+	}
+	out = wrapComments(out, e.commentStyle(), e.Header, e.Footer)
+
+	_, err = e.w.Write(out)
+	return err
+}
+
+// print renders file with the HCL printer, applying the Encoder's formatting
+// options, or as is if Unformatted is set.
+func (e *Encoder) print(file *ast.File) ([]byte, error) {
 	b := &bytes.Buffer{}
-	err = printer.Fprint(b, file)
+
+	if e.Unformatted {
+		printRaw(b, file.Node)
+		b.WriteString("\n")
+		return b.Bytes(), nil
+	}
+
+	if _, err := positionNodes(file, startingCursor, e.indent(), e.ListInlineMaxLen); err != nil {
+		return nil, err
+	}
+
+	if err := (&printer.Config{SpacesWidth: e.indent()}).Fprint(b, file); err != nil {
+		return nil, err
+	}
 	b.WriteString("\n")
 
-	return b.Bytes(), err
+	out := b.Bytes()
+	if e.BlockSpacing != 1 {
+		out = spaceBlocks(out, e.BlockSpacing)
+	}
+	if e.SingleSpaceEquals {
+		out = singleSpaceEquals(out)
+	}
+	return out, nil
+}
+
+// spaceBlocks normalizes the number of blank lines between the top-level items
+// of the printed HCL in b to n. Lines that continue the previous item, such as
+// closing braces or those following a comment, are left untouched.
+func spaceBlocks(b []byte, n int) []byte {
+	if n < 0 {
+		n = 0
+	}
+
+	lines := bytes.Split(b, []byte("\n"))
+	out := make([][]byte, 0, len(lines))
+	var prev, heredoc []byte
+	blanks := 0
+
+	for _, line := range lines {
+		if heredoc != nil {
+			out = append(out, line)
+			if bytes.Equal(bytes.TrimSpace(line), heredoc) {
+				heredoc = nil
+				prev = line
+			}
+			continue
+		}
+
+		if len(line) == 0 {
+			blanks++
+			continue
+		}
+
+		if prev != nil && startsItem(line) && !isComment(prev) {
+			blanks = n
+		}
+		for ; blanks > 0; blanks-- {
+			out = append(out, nil)
+		}
+
+		out = append(out, line)
+		prev = line
+		heredoc = heredocAnchor(line)
+	}
+
+	return append(bytes.Join(out, []byte("\n")), '\n')
+}
+
+// singleSpaceEquals collapses the whitespace between each attribute key and
+// its equals sign in the printed HCL in b to a single space. Lines within
+// heredocs are left untouched.
+func singleSpaceEquals(b []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	var heredoc []byte
+
+	for i, line := range lines {
+		if heredoc != nil {
+			if bytes.Equal(bytes.TrimSpace(line), heredoc) {
+				heredoc = nil
+			}
+			continue
+		}
+		heredoc = heredocAnchor(line)
+
+		key := keyLen(line)
+		if key == 0 {
+			continue
+		}
+
+		rest := bytes.TrimLeft(line[key:], " \t")
+		if len(rest) < 2 || rest[0] != '=' || rest[1] == '=' {
+			continue
+		}
+
+		out := make([]byte, 0, len(line))
+		out = append(out, line[:key]...)
+		out = append(out, ' ')
+		lines[i] = append(out, rest...)
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// heredocAnchor returns the anchor of the heredoc opened at the end of the
+// printed line, such as an attribute value or list element, or nil if the
+// line does not open one.
+func heredocAnchor(line []byte) []byte {
+	line = bytes.TrimRight(line, " \t")
+	i := bytes.LastIndex(line, []byte("<<"))
+	if i < 0 {
+		return nil
+	}
+	if pre := bytes.TrimRight(line[:i], " \t"); len(pre) > 0 && !bytes.ContainsAny(pre[len(pre)-1:], "=[,") {
+		return nil
+	}
+
+	anchor := bytes.TrimPrefix(line[i+2:], []byte("-"))
+	if len(anchor) == 0 {
+		return nil
+	}
+	for _, c := range anchor {
+		if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return nil
+		}
+	}
+	return anchor
+}
+
+// keyLen returns the length of the indentation and the leading identifier or
+// quoted string key of line, or 0 if line does not start with a key.
+func keyLen(line []byte) int {
+	i := len(line) - len(bytes.TrimLeft(line, " \t"))
+	if i == len(line) {
+		return 0
+	}
+
+	if line[i] == '"' {
+		for j := i + 1; j < len(line); j++ {
+			switch line[j] {
+			case '\\':
+				j++
+			case '"':
+				return j + 1
+			}
+		}
+		return 0
+	}
+
+	j := i
+	for j < len(line) && line[j] != ' ' && line[j] != '\t' && line[j] != '=' {
+		j++
+	}
+	if j == i || isComment(line[i:]) {
+		return 0
+	}
+	return j
+}
+
+// wrapComments surrounds the printed HCL in b with the header and footer
+// comment lines, each separated from b by a blank line.
+func wrapComments(b []byte, style CommentStyle, header, footer []string) []byte {
+	if len(header) == 0 && len(footer) == 0 {
+		return b
+	}
+
+	body := bytes.TrimSpace(b)
+	out := &bytes.Buffer{}
+
+	writeComments(out, style, header)
+	if len(header) > 0 && len(body) > 0 {
+		out.WriteByte('\n')
+	}
+
+	if len(body) > 0 {
+		out.Write(body)
+		out.WriteByte('\n')
+	}
+
+	if len(footer) > 0 && out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	writeComments(out, style, footer)
+
+	return out.Bytes()
+}
+
+// writeComments writes each of lines to b as a single-line comment.
+func writeComments(b *bytes.Buffer, style CommentStyle, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	for _, c := range commentGroup(style, strings.Join(lines, "\n")).List {
+		b.WriteString(c.Text)
+		b.WriteByte('\n')
+	}
+}
+
+// startsItem returns true if line is the start of a top-level item
+func startsItem(line []byte) bool {
+	switch line[0] {
+	case ' ', '\t', '}', ']':
+		return false
+	default:
+		return true
+	}
+}
+
+// isComment returns true if line is a single-line comment
+func isComment(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return bytes.HasPrefix(line, []byte("#")) || bytes.HasPrefix(line, []byte("//"))
 }
@@ -1,18 +1,38 @@
 package hclencoder
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
 
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
 	"github.com/stretchr/testify/assert"
 )
 
 type encoderTest struct {
-	ID     string
-	Input  interface{}
-	Output string
-	Error  bool
+	ID        string
+	Input     interface{}
+	Output    string
+	Error     bool
+	Configure func(*Encoder)
 }
 
 func TestEncoder(t *testing.T) {
@@ -143,6 +163,238 @@ func TestEncoder(t *testing.T) {
 			},
 			Output: "nested-struct-slice-no-key",
 		},
+		{
+			ID:     "root map from json",
+			Input:  jsonMap(`{"region": "us-east-1", "replicas": 3, "enabled": true, "tags": ["a", "b"], "limits": {"cpu": 0.5, "memory": "512Mi"}, "unset": null}`),
+			Output: "root-json-map",
+		},
+		{
+			ID: "root map of structs",
+			Input: map[string]TestStruct{
+				"foo": {"fizz"},
+				"bar": {"buzz"},
+			},
+			Output: "root-struct-map",
+		},
+		{
+			ID:     "root primitive",
+			Input:  "foo",
+			Output: "root-primitive",
+		},
+		{
+			ID:     "root list",
+			Input:  []int{1, 2, 3},
+			Output: "root-list",
+		},
+		{
+			ID:     "root keyed struct list",
+			Input:  []ChanWidget{{"foo", 1}, {"bar", 2}},
+			Output: "root-keyed-struct-list",
+		},
+		{
+			ID:    "root unsupported kind",
+			Input: func() {},
+			Error: true,
+		},
+		{
+			ID: "drained channel",
+			Input: struct {
+				Widget chan ChanWidget `hcl:"widget"`
+			}{testChan(ChanWidget{"foo", 1}, ChanWidget{"bar", 2}, ChanWidget{"baz", 3})},
+			Output:    "drained-channel",
+			Configure: func(e *Encoder) { e.DrainChannels = true },
+		},
+		{
+			ID: "channel not drained",
+			Input: struct {
+				Widget chan ChanWidget `hcl:"widget"`
+			}{testChan(ChanWidget{"foo", 1})},
+			Error: true,
+		},
+		{
+			ID:        "block spacing - none",
+			Input:     spacingInput,
+			Output:    "block-spacing-none",
+			Configure: func(e *Encoder) { e.BlockSpacing = 0 },
+		},
+		{
+			ID:        "block spacing - double",
+			Input:     spacingInput,
+			Output:    "block-spacing-double",
+			Configure: func(e *Encoder) { e.BlockSpacing = 2 },
+		},
+		{
+			ID:     "header and footer comments",
+			Input:  spacingInput,
+			Output: "header-footer",
+			Configure: func(e *Encoder) {
+				e.Header = []string{"This file is generated.", "Do not edit."}
+				e.Footer = []string{"end of file"}
+			},
+		},
+		{
+			ID: "split map key",
+			Input: struct {
+				Settings map[string]interface{} `hcl:"settings" hcle:"split:logging"`
+				After    string                 `hcl:"after"`
+			}{
+				Settings: map[string]interface{}{
+					"level":   1,
+					"logging": ChanWidget{Name: "stdout", Size: 2},
+					"mode":    "fast",
+				},
+				After: "done",
+			},
+			Output: "split-map-key",
+		},
+		{
+			ID: "custom map key sort",
+			Input: map[string]interface{}{
+				"b":      2,
+				"a":      1,
+				"c":      3,
+				"widget": []ChanWidget{{"y", 1}, {"x", 2}},
+			},
+			Output: "map-key-sort",
+			Configure: func(e *Encoder) {
+				e.MapKeySort = func(keys []string) { sort.Sort(sort.Reverse(sort.StringSlice(keys))) }
+			},
+		},
+		{
+			ID: "custom map key order",
+			Input: struct {
+				Labels map[string]string `hcl:"labels"`
+				Ports  map[string]int    `hcl:"ports"`
+			}{
+				Labels: map[string]string{"team": "core", "version": "1.2", "name": "web", "app": "api"},
+				Ports:  map[string]int{"name": 1, "http": 80},
+			},
+			Output: "map-key-order",
+			Configure: func(e *Encoder) {
+				e.MapKeyOrder = map[reflect.Type][]string{
+					reflect.TypeOf(map[string]string{}): {"name", "missing", "version"},
+				}
+				e.MapKeySort = func(keys []string) { sort.Sort(sort.Reverse(sort.StringSlice(keys))) }
+			},
+		},
+		{
+			ID:        "heredoc spacing - none",
+			Input:     heredocInput,
+			Output:    "heredoc-spacing-none",
+			Configure: func(e *Encoder) { e.BlockSpacing = 0 },
+		},
+		{
+			ID:        "heredoc spacing - double",
+			Input:     heredocInput,
+			Output:    "heredoc-spacing-double",
+			Configure: func(e *Encoder) { e.BlockSpacing = 2 },
+		},
+		{
+			ID: "heredoc",
+			Input: struct {
+				Script string   `hcl:"script" hcle:"heredoc"`
+				Name   string   `hcl:"name"`
+				Steps  []string `hcl:"steps" hcle:"heredoc"`
+			}{
+				Script: "echo ${var.greeting}\nEOF\nexit 0",
+				Name:   "${var.name}",
+				Steps:  []string{"make\n", "make test\n"},
+			},
+			Output: "heredoc",
+		},
+		{
+			ID: "dotted field names",
+			Input: struct {
+				Name        string            `hcl:"name"`
+				Labels      map[string]string `hcl:"metadata.labels"`
+				Namespace   string            `hcl:"metadata.namespace"`
+				Replicas    int               `hcl:"spec.replicas"`
+				Annotations map[string]string `hcl:"metadata.annotations" hcle:"omitempty"`
+				Image       string            `hcl:"spec.template.image" hcle:"comment:The container image"`
+			}{
+				Name:      "web",
+				Labels:    map[string]string{"app": "web"},
+				Namespace: "default",
+				Replicas:  3,
+				Image:     "nginx",
+			},
+			Output: "dotted-names",
+		},
+		{
+			ID: "list index comments",
+			Input: struct {
+				Zones []string `hcl:"zones"`
+			}{[]string{"a", "b", "c"}},
+			Output:    "list-index-comments",
+			Configure: func(e *Encoder) { e.ListIndexComments = true },
+		},
+		{
+			ID: "dynamic blocks",
+			Input: struct {
+				Name    string           `hcl:"name"`
+				Ingress []DynamicIngress `hcl:"ingress,dynamic" hcle:"foreach:var.ingress_rules"`
+				Egress  DynamicIngress   `hcl:"egress,dynamic" hcle:"foreach:var.egress_rules"`
+			}{
+				Name:    "web",
+				Ingress: []DynamicIngress{{Port: "ingress.value.port", Protocol: "tcp"}},
+				Egress:  DynamicIngress{Port: "egress.value.port", Protocol: "udp"},
+			},
+			Output: "dynamic-blocks",
+		},
+		{
+			ID: "dynamic blocks - struct slices as blocks",
+			Input: struct {
+				Name    string           `hcl:"name"`
+				Ingress []DynamicIngress `hcl:"ingress,dynamic" hcle:"foreach:var.ingress_rules"`
+				Egress  DynamicIngress   `hcl:"egress,dynamic" hcle:"foreach:var.egress_rules"`
+			}{
+				Name:    "web",
+				Ingress: []DynamicIngress{{Port: "ingress.value.port", Protocol: "tcp"}},
+				Egress:  DynamicIngress{Port: "egress.value.port", Protocol: "udp"},
+			},
+			Output:    "dynamic-blocks",
+			Configure: func(e *Encoder) { e.StructSlicesAsBlocks = true },
+		},
+		{
+			ID: "dynamic blocks - keyed content",
+			Input: struct {
+				Widgets []ChanWidget `hcl:"widget,dynamic" hcle:"foreach:var.widgets"`
+			}{[]ChanWidget{{"a", 1}}},
+			Error: true,
+		},
+		{
+			ID: "dynamic blocks - multiple templates",
+			Input: struct {
+				Ingress []DynamicIngress `hcl:"ingress,dynamic" hcle:"foreach:var.ingress_rules"`
+			}{[]DynamicIngress{{Port: "ingress.value.port"}, {Port: "ingress.value.port"}}},
+			Error: true,
+		},
+		{
+			ID: "dynamic blocks - not a struct",
+			Input: struct {
+				Zones []string `hcl:"zones,dynamic" hcle:"foreach:var.zones"`
+			}{[]string{"a"}},
+			Error: true,
+		},
+		{
+			ID: "dedupe blocks",
+			Input: struct {
+				Widgets []ChanWidget `hcl:"widget"`
+			}{[]ChanWidget{{"a", 1}, {"b", 2}, {"a", 1}, {"a", 3}, {"b", 2}}},
+			Output:    "dedupe-blocks",
+			Configure: func(e *Encoder) { e.DedupeBlocks = true },
+		},
+		{
+			ID: "map of struct pointers with nil values",
+			Input: struct {
+				Widgets map[string]*TestStruct `hcl:"widget"`
+				Keyed   map[string]*ChanWidget `hcl:"keyed"`
+			}{
+				Widgets: map[string]*TestStruct{"a": {"x"}, "b": nil, "c": {"y"}},
+				Keyed:   map[string]*ChanWidget{"a": {"x", 1}, "b": nil},
+			},
+			Output: "map-nil-struct-pointers",
+		},
 		{
 			ID: "nested slices",
 			Input: map[string]interface{}{
@@ -163,10 +415,26 @@ func TestEncoder(t *testing.T) {
 			},
 			Output: "nested-slices",
 		},
+		{
+			ID:     "lead comments",
+			Input:  commentInput,
+			Output: "comments",
+		},
+		{
+			ID:     "inline comments",
+			Input:  inlineCommentInput,
+			Output: "inline-comments",
+		},
 	}
 
 	for _, test := range tests {
-		actual, err := Encode(test.Input)
+		b := &bytes.Buffer{}
+		enc := NewEncoder(b)
+		if test.Configure != nil {
+			test.Configure(enc)
+		}
+		err := enc.Encode(test.Input)
+		actual := b.Bytes()
 
 		if test.Error {
 			assert.Error(t, err, test.ID)
@@ -186,3 +454,1382 @@ func TestEncoder(t *testing.T) {
 		}
 	}
 }
+
+func TestEncode_EscapedStringsRoundTrip(t *testing.T) {
+	tests := []string{
+		`trailing\`,
+		`C:\path\"dir"`,
+		`say "hi" to ${var.name}`,
+		`${lookup(var.foo, "bar")}`,
+		`${unterminated "x"`,
+		`$${"literal"}`,
+		"tab\there\nnew",
+		"bell\a",
+	}
+
+	for _, test := range tests {
+		b, err := Encode(map[string]string{"value": test})
+		assert.NoError(t, err, test)
+
+		var out map[string]string
+		assert.NoError(t, hcl.Unmarshal(b, &out), test)
+		assert.Equal(t, test, out["value"], test)
+	}
+}
+
+type RoundTripAnimal struct {
+	Name  string `hcl:",key"`
+	Sound string `hcl:"says"`
+}
+
+type RoundTripFarm struct {
+	Name  string `hcl:"name"`
+	Owned bool   `hcl:"owned"`
+}
+
+type RoundTripConfig struct {
+	RoundTripFarm `hcl:",squash"`
+	Location      []float64         `hcl:"location"`
+	Acres         float64           `hcl:"acres"`
+	Farmer        struct{ Age int } `hcl:"farmer"`
+	Animals       []RoundTripAnimal `hcl:"animal"`
+	Buildings     map[string]string `hcl:"buildings"`
+	Secret        string            `hcle:"omit"`
+}
+
+func TestDecode_RoundTrip(t *testing.T) {
+	in := RoundTripConfig{
+		RoundTripFarm: RoundTripFarm{Name: `Ol' "McDonald's" ${farm}`, Owned: true},
+		Location:      []float64{12.34, -5.67},
+		Acres:         3,
+		Animals:       []RoundTripAnimal{{"cow", "moo"}, {"pig", "oink"}},
+		Buildings:     map[string]string{"Barn": "456 Digits Drive"},
+		Secret:        "please-dont-share-me",
+	}
+	in.Farmer.Age = 65
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+
+	var out RoundTripConfig
+	assert.NoError(t, Decode(b, &out))
+
+	in.Secret = ""
+	assert.Equal(t, in, out)
+}
+
+func TestEncode_RootAsBlock(t *testing.T) {
+	in := RoundTripConfig{
+		RoundTripFarm: RoundTripFarm{Name: "Ol' McDonald's Farm", Owned: true},
+		Location:      []float64{12.34, -5.67},
+		Animals:       []RoundTripAnimal{{"cow", "moo"}},
+		Buildings:     map[string]string{"Barn": "456 Digits Drive"},
+	}
+	in.Farmer.Age = 65
+
+	b := &bytes.Buffer{}
+	enc := NewEncoder(b)
+	enc.RootAsBlock = "config"
+	assert.NoError(t, enc.Encode(in))
+
+	expected, err := ioutil.ReadFile("_tests/root-as-block.hcl")
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), b.String())
+
+	var out struct {
+		Config RoundTripConfig `hcl:"config"`
+	}
+	assert.NoError(t, Decode(b.Bytes(), &out))
+	assert.Equal(t, in, out.Config)
+
+	b.Reset()
+	assert.NoError(t, enc.Encode(RoundTripAnimal{"cow", "moo"}))
+	assert.Equal(t, "config \"cow\" {\n  says = \"moo\"\n}\n", b.String())
+
+	assert.EqualError(t, enc.Encode([]string{"a"}), "root value must be a struct or map to be encoded as a config block")
+}
+
+func TestEncode_MapBlocks(t *testing.T) {
+	type server struct {
+		Host string `hcl:"host"`
+		Port int    `hcl:"port"`
+	}
+	type config struct {
+		Servers map[string]*server `hcl:"server,blocks"`
+	}
+
+	in := config{Servers: map[string]*server{
+		"web": {Host: "example.com", Port: 80},
+		"api": {Host: "api.example.com", Port: 443},
+	}}
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, `server "api" {
+  host = "api.example.com"
+  port = 443
+}
+
+server "web" {
+  host = "example.com"
+  port = 80
+}
+`, string(b))
+
+	var out config
+	assert.NoError(t, Decode(b, &out))
+	assert.Equal(t, in, out)
+
+	_, err = Encode(struct {
+		Tags map[string]string `hcl:"tags,blocks"`
+	}{map[string]string{"a": "b"}})
+	assert.EqualError(t, err, `field "tags": blocks must be structs, string given`)
+}
+
+func TestEncode_MixedBlocks(t *testing.T) {
+	type server struct {
+		Host string `hcl:"host"`
+	}
+	type named struct {
+		Name string `hcl:",key"`
+		Host string `hcl:"host"`
+	}
+
+	b, err := Encode(struct {
+		Servers []interface{} `hcl:"server,blocks"`
+	}{[]interface{}{
+		server{Host: "a.example.com"},
+		&named{Name: "web", Host: "b.example.com"},
+		map[string]string{"host": "c.example.com"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, `server {
+  host = "a.example.com"
+}
+
+server "web" {
+  host = "b.example.com"
+}
+
+server {
+  host = "c.example.com"
+}
+`, string(b))
+
+	_, err = Encode(struct {
+		Servers []interface{} `hcl:"server,blocks"`
+	}{[]interface{}{server{Host: "a.example.com"}, "b.example.com"}})
+	assert.EqualError(t, err, `field "server[1]": blocks must be structs, string given`)
+
+	_, err = Encode(struct {
+		Hosts []string `hcl:"host,blocks"`
+	}{[]string{"a.example.com"}})
+	assert.EqualError(t, err, `field "host": blocks must be structs, string given`)
+}
+
+func TestEncode_StructSlicesAsBlocks(t *testing.T) {
+	type server struct {
+		Host string `hcl:"host"`
+	}
+	type config struct {
+		Servers []server          `hcl:"server"`
+		Animals []RoundTripAnimal `hcl:"animal"`
+		Values  []interface{}     `hcl:"values"`
+	}
+
+	in := config{
+		Servers: []server{{"a.example.com"}, {"b.example.com"}},
+		Animals: []RoundTripAnimal{{"cow", "moo"}},
+		Values:  []interface{}{server{"c.example.com"}, 1},
+	}
+	b, err := Encode(in, WithStructSlicesAsBlocks())
+	assert.NoError(t, err)
+	assert.Equal(t, `server {
+  host = "a.example.com"
+}
+
+server {
+  host = "b.example.com"
+}
+
+animal "cow" {
+  says = "moo"
+}
+
+values = [
+  {
+    host = "c.example.com"
+  },
+  1,
+]
+`, string(b))
+
+	var out config
+	assert.NoError(t, Decode(b, &out))
+	assert.Equal(t, in.Servers, out.Servers)
+	assert.Equal(t, in.Animals, out.Animals)
+
+	// the blocks tag is stricter than the option
+	_, err = Encode(struct {
+		Values []interface{} `hcl:"values,blocks"`
+	}{in.Values}, WithStructSlicesAsBlocks())
+	assert.EqualError(t, err, `field "values[1]": blocks must be structs, int given`)
+}
+
+func TestEncode_Truncation(t *testing.T) {
+	list := make([]int, 100)
+	for i := range list {
+		list[i] = i
+	}
+	in := struct {
+		List []int          `hcl:"list"`
+		Map  map[string]int `hcl:"map"`
+		Few  []int          `hcl:"few"`
+	}{list, map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7}, []int{1, 2}}
+
+	b, err := Encode(in, WithTruncation(5))
+	assert.NoError(t, err)
+	assert.Equal(t, `list = [
+  0,
+  1,
+  2,
+  3,
+  4, # ... and 95 more
+]
+
+map {
+  a = 1
+  b = 2
+  c = 3
+  d = 4
+  e = 5 # ... and 2 more
+}
+
+few = [
+  1,
+  2,
+]
+`, string(b))
+
+	b, err = Encode(in, WithTruncation(1), func(e *Encoder) { e.ListIndexComments = true })
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "list = [\n  0, # 0 ... and 99 more\n]")
+
+	nested := struct {
+		Lists [][]int `hcl:"lists"`
+	}{[][]int{{1, 2}, {3}, {4}}}
+	b, err = Encode(nested, WithTruncation(2))
+	assert.NoError(t, err)
+	assert.Equal(t, "lists = [\n  [\n    1,\n    2,\n  ],\n  [3],\n] # ... and 1 more\n", string(b))
+}
+
+func TestEncode_OmitEmptyLastField(t *testing.T) {
+	type object struct {
+		A string `hcl:"a"`
+		B string `hcl:"b" hcle:"omitempty"`
+	}
+	type config struct {
+		Objects []object `hcl:"objects"`
+	}
+	in := config{Objects: []object{{A: "x"}, {A: "y", B: "z"}}}
+
+	for _, opts := range [][]Option{nil, {WithoutFormatting()}} {
+		b, err := Encode(in, opts...)
+		assert.NoError(t, err)
+
+		var out config
+		assert.NoError(t, Decode(b, &out), string(b))
+		assert.Equal(t, in, out)
+	}
+}
+
+func TestEncode_InheritLabels(t *testing.T) {
+	type check struct {
+		Path string `hcl:"path"`
+	}
+	type probe struct {
+		Name string `hcl:",key"`
+		Path string `hcl:"path"`
+	}
+	type service struct {
+		Check  check   `hcl:"check" hcle:"inheritlabels"`
+		Probes []probe `hcl:"probe" hcle:"inheritlabels"`
+		Name   string  `hcl:",key"`
+	}
+
+	b, err := Encode(struct {
+		Services []service `hcl:"service"`
+	}{[]service{{Name: "web", Check: check{"/health"}, Probes: []probe{{"ready", "/ready"}}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, `service "web" {
+  check "web" {
+    path = "/health"
+  }
+
+  probe "web" "ready" {
+    path = "/ready"
+  }
+}
+`, string(b))
+
+	_, err = Encode(struct {
+		Name string `hcl:",key"`
+		Port int    `hcl:"port" hcle:"inheritlabels"`
+	}{"web", 80})
+	assert.EqualError(t, err, `field "port": inheritlabels requires a block`)
+}
+
+func TestEncode_EmptyLabels(t *testing.T) {
+	type optional struct {
+		Name string `hcl:",key" hcle:"omitempty"`
+		Says string `hcl:"says"`
+	}
+	in := struct {
+		Optional []optional        `hcl:"optional"`
+		Required []RoundTripAnimal `hcl:"required"`
+	}{
+		Optional: []optional{{"", "baa"}, {"cow", "moo"}},
+		Required: []RoundTripAnimal{{"", "oink"}},
+	}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, `optional "cow" {
+  says = "moo"
+}
+
+required "" {
+  says = "oink"
+}
+`, string(b))
+
+	_, err = Encode(in, WithRequireLabels())
+	assert.EqualError(t, err, `field "required[0].Name": struct key fields cannot be empty`)
+}
+
+func TestEncode_DuplicateAttributes(t *testing.T) {
+	type Named struct {
+		Name string `hcl:"name"`
+	}
+	type Labeled struct {
+		Name string `hcl:"name"`
+	}
+	type block struct {
+		Named `hcl:",squash"`
+		Name  string `hcl:"name"`
+	}
+
+	_, err := Encode(struct {
+		Named   `hcl:",squash"`
+		Labeled `hcl:",squash"`
+	}{})
+	assert.EqualError(t, err, `duplicate attribute "name"`)
+
+	_, err = Encode(struct {
+		Blocks []block `hcl:"block"`
+	}{[]block{{}}})
+	assert.EqualError(t, err, `field "block[0]": duplicate attribute "name"`)
+
+	_, err = Encode(struct {
+		Named `hcl:",squash"`
+		Block Named `hcl:"name"`
+	}{})
+	assert.EqualError(t, err, `duplicate attribute "name"`)
+
+	// repeated blocks are not attributes
+	b, err := Encode(struct {
+		A Named `hcl:"named"`
+		B Named `hcl:"named"`
+	}{Named{"a"}, Named{"b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "named {\n  name = \"a\"\n}\n\nnamed {\n  name = \"b\"\n}\n", string(b))
+}
+
+func TestEncode_StringEscaper(t *testing.T) {
+	// escape only quotes, leaving tabs for a downstream tool
+	escaper := strings.NewReplacer(`"`, `\"`).Replace
+
+	b, err := Encode(struct {
+		Value    string            `hcl:"value"`
+		Template string            `hcl:"template" hcle:"literal"`
+		Env      map[string]string `hcl:"env"`
+	}{
+		Value:    "a\t\"b\"",
+		Template: "${x}\t",
+		Env:      map[string]string{"tab\tkey": "c\td"},
+	}, WithStringEscaper(escaper))
+	assert.NoError(t, err)
+	assert.Equal(t, "value = \"a\t\\\"b\\\"\"\n\ntemplate = \"$${x}\t\"\n\nenv {\n  \"tab\\tkey\" = \"c\td\"\n}\n", string(b))
+
+	type animal struct {
+		Name  string `hcl:",key"`
+		Sound string `hcl:"says"`
+	}
+	b, err = Encode(struct {
+		Animals []animal `hcl:"animal"`
+	}{[]animal{{"cow", "moo"}}}, WithStringEscaper(strings.ToUpper))
+	assert.NoError(t, err)
+	assert.Equal(t, "animal \"cow\" {\n  says = \"MOO\"\n}\n", string(b))
+}
+
+func TestMustEncode(t *testing.T) {
+	assert.Equal(t, "foo = \"bar\"\n", string(MustEncode(map[string]string{"foo": "bar"})))
+	assert.PanicsWithError(t, `hclencoder: field "value": cannot encode non-finite float NaN to HCL`, func() {
+		MustEncode(map[string]float64{"value": math.NaN()})
+	})
+}
+
+func TestEncode_Formatter(t *testing.T) {
+	upper := func(b []byte) ([]byte, error) { return bytes.ToUpper(b), nil }
+	in := map[string]string{"foo": "bar"}
+
+	b, err := Encode(in, WithFormatter(upper), func(e *Encoder) { e.Header = []string{"generated"} })
+	assert.NoError(t, err)
+	assert.Equal(t, "# generated\n\nFOO = \"BAR\"\n", string(b))
+
+	b, err = Encode(in, WithoutFormatting(), WithFormatter(upper))
+	assert.NoError(t, err)
+	assert.Equal(t, "FOO = \"BAR\"\n", string(b))
+
+	_, err = Encode(in, WithFormatter(func([]byte) ([]byte, error) { return nil, errors.New("lint failed") }))
+	assert.EqualError(t, err, "lint failed")
+}
+
+func TestEncodeToFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.hcl")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("old"), 0644))
+
+	assert.NoError(t, EncodeToFile(path, map[string]string{"foo": "bar"}, 0600))
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo = \"bar\"\n", string(b))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.Error(t, EncodeToFile(path, map[string]float64{"value": math.NaN()}, 0600))
+	b, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo = \"bar\"\n", string(b))
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, EncodeToFile(filepath.Join(dir, "missing", "config.hcl"), map[string]string{}, 0600))
+}
+
+func TestEncode_Sensitive(t *testing.T) {
+	type credentials struct {
+		Name     string `hcl:",key"`
+		User     string `hcl:"user"`
+		Password string `hcl:"password"`
+	}
+	in := struct {
+		Host        string            `hcl:"host"`
+		Token       string            `hcl:"token" hcle:"sensitive"`
+		Credentials []credentials     `hcl:"credentials" hcle:"sensitive"`
+		Env         map[string]string `hcl:"env" hcle:"sensitive"`
+	}{
+		Host:        "example.com",
+		Token:       "s3cr3t",
+		Credentials: []credentials{{"db", "admin", "hunter2"}},
+		Env:         map[string]string{"API_KEY": "abc123"},
+	}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, `host = "example.com"
+
+token = "***REDACTED***"
+
+credentials "db" {
+  user     = "***REDACTED***"
+  password = "***REDACTED***"
+}
+
+env {
+  API_KEY = "***REDACTED***"
+}
+`, string(b))
+
+	b, err = Encode(in, func(e *Encoder) { e.RedactedText = "<hidden>" })
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `token = "<hidden>"`)
+
+	b, err = Encode(in, WithOmitSensitive())
+	assert.NoError(t, err)
+	assert.Equal(t, "host = \"example.com\"\n", string(b))
+}
+
+func TestEncode_SensitiveComposite(t *testing.T) {
+	loc := time.FixedZone("EST", -5*60*60)
+	in := struct {
+		At  time.Time `hcl:"at" hcle:"withzone,sensitive"`
+		Raw ast.Node  `hcl:"raw" hcle:"sensitive"`
+	}{
+		At: time.Date(2020, 1, 2, 3, 4, 5, 0, loc),
+		Raw: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{{
+			Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "password"}}},
+			Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"hunter2"`}},
+		}}}},
+	}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "2020")
+	assert.NotContains(t, string(b), "EST")
+	assert.NotContains(t, string(b), "hunter2")
+	assert.Equal(t, `at {
+  time = "***REDACTED***"
+  zone = "***REDACTED***"
+}
+
+raw {
+  password = "***REDACTED***"
+}
+`, string(b))
+}
+
+func TestEncode_UnexportedFields(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		ID    string
+		Input interface{}
+	}{
+		{"ast.LiteralType", struct {
+			A string
+			n ast.LiteralType
+		}{"a", ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}}}},
+		{"big.Int", struct {
+			A string
+			n *big.Int
+		}{"a", big.NewInt(1)}},
+		{"big.Float", struct {
+			A string
+			n big.Float
+		}{"a", *big.NewFloat(1.5)}},
+		{"big.Rat", struct {
+			A string
+			n *big.Rat
+		}{"a", big.NewRat(1, 2)}},
+		{"net.IPNet", struct {
+			A string
+			n *net.IPNet
+		}{"a", ipNet}},
+		{"url.URL", struct {
+			A string
+			n url.URL
+		}{"a", url.URL{Scheme: "https", Host: "example.com"}}},
+		{"regexp.Regexp", struct {
+			A  string
+			re *regexp.Regexp
+		}{"a", regexp.MustCompile("^a+$")}},
+		{"sql.NullString", struct {
+			A string
+			n sql.NullString
+		}{"a", sql.NullString{String: "x", Valid: true}}},
+		{"formatdate", struct {
+			A  string
+			at time.Time `hcle:"formatdate:YYYY"`
+		}{"a", time.Unix(0, 0)}},
+		{"stringer", struct {
+			A     string
+			color Color `hcle:"stringer"`
+		}{"a", Color(1)}},
+		{"withzone", struct {
+			A  string
+			at time.Time `hcle:"withzone"`
+		}{"a", time.Unix(0, 0)}},
+	}
+
+	for _, test := range tests {
+		b, err := Encode(test.Input)
+		assert.NoError(t, err, test.ID)
+		assert.Equal(t, "A = \"a\"\n", string(b), test.ID)
+	}
+}
+
+func TestEncode_UnsafePointer(t *testing.T) {
+	n := 1
+	in := struct {
+		Name string         `hcl:"name"`
+		Ptr  unsafe.Pointer `hcl:"ptr"`
+		Fn   func()         `hcl:"fn"`
+	}{Name: "foo", Ptr: unsafe.Pointer(&n)}
+
+	_, err := Encode(in)
+	assert.EqualError(t, err, `field "ptr": cannot encode unsafe.Pointer to HCL, the memory it points to is untyped`)
+
+	in.Ptr = nil
+	_, err = Encode(in)
+	assert.EqualError(t, err, `field "ptr": cannot encode unsafe.Pointer to HCL, the memory it points to is untyped`)
+
+	in.Ptr, in.Fn = unsafe.Pointer(&n), func() {}
+	b, err := Encode(in, WithSkipUnsupported())
+	assert.NoError(t, err)
+	assert.Equal(t, "name = \"foo\"\n", string(b))
+}
+
+func TestEncode_MultiError(t *testing.T) {
+	type listener struct {
+		Port  float64 `hcl:"port"`
+		Scale float64 `hcl:"scale"`
+	}
+	in := struct {
+		Name      string     `hcl:"name"`
+		Ratio     float64    `hcl:"ratio"`
+		Listeners []listener `hcl:"listener"`
+		Handler   func()     `hcl:"handler"`
+	}{
+		Name:      "foo",
+		Ratio:     math.Inf(1),
+		Listeners: []listener{{Port: 80, Scale: math.NaN()}, {Port: math.NaN()}},
+		Handler:   func() {},
+	}
+
+	_, err := Encode(in)
+	assert.EqualError(t, err, `field "ratio": cannot encode non-finite float +Inf to HCL`)
+
+	b := &bytes.Buffer{}
+	err = NewEncoder(b, WithMultiError()).Encode(in)
+	assert.EqualError(t, err, strings.Join([]string{
+		`field "ratio": cannot encode non-finite float +Inf to HCL`,
+		`field "listener[0].scale": cannot encode non-finite float NaN to HCL`,
+		`field "listener[1].port": cannot encode non-finite float NaN to HCL`,
+		`field "handler": cannot encode func() of kind func to HCL`,
+	}, "\n"))
+	assert.Empty(t, b.String())
+
+	var pe *pathError
+	assert.True(t, errors.As(err, &pe))
+	assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 4)
+
+	_, err = Encode(in.Listeners[1], WithMultiError())
+	assert.EqualError(t, err, `field "port": cannot encode non-finite float NaN to HCL`)
+}
+
+func TestEncode_Sections(t *testing.T) {
+	type volume struct {
+		Size int `hcl:"size"`
+	}
+	in := struct {
+		Name   string `hcl:"name"`
+		Host   string `hcl:"host" hcle:"section:Networking"`
+		Volume volume `hcl:"volume" hcle:"section:Storage"`
+		Port   int    `hcl:"port" hcle:"section:Networking,comment:The listening port"`
+		Proxy  string `hcl:"proxy" hcle:"section:Proxy,omitempty"`
+		Debug  bool   `hcl:"debug"`
+	}{Name: "web", Host: "example.com", Volume: volume{10}, Port: 80, Debug: true}
+
+	b, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, `name = "web"
+
+debug = true
+
+# Networking
+host = "example.com"
+
+# The listening port
+port = 80
+
+# Storage
+volume {
+  size = 10
+}
+`, string(b))
+}
+
+func TestEncode_SortedFields(t *testing.T) {
+	type listener struct {
+		Protocol string `hcl:",key"`
+		Port     int    `hcl:"port"`
+		Host     string `hcl:",key"`
+		Address  string `hcl:"address"`
+	}
+	in := struct {
+		Zone      string     `hcl:"zone"`
+		Listeners []listener `hcl:"listener"`
+		Name      string     `hcl:"name"`
+		Backup    string     `hcl:"backup" hcle:"section:Storage"`
+		Archive   string     `hcl:"archive" hcle:"section:Storage"`
+	}{
+		Zone:      "a",
+		Listeners: []listener{{"https", 443, "example.com", "0.0.0.0"}, {"http", 80, "example.com", "0.0.0.0"}},
+		Name:      "web",
+		Backup:    "daily",
+		Archive:   "weekly",
+	}
+
+	b, err := Encode(in, WithSortedFields())
+	assert.NoError(t, err)
+	assert.Equal(t, `listener "https" "example.com" {
+  address = "0.0.0.0"
+  port    = 443
+}
+
+listener "http" "example.com" {
+  address = "0.0.0.0"
+  port    = 80
+}
+
+name = "web"
+
+zone = "a"
+
+# Storage
+archive = "weekly"
+
+backup = "daily"
+`, string(b))
+}
+
+type JSONLimits struct {
+	cpu, memory int
+}
+
+func (l JSONLimits) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"cpu": l.cpu, "memory": fmt.Sprintf("%dMi", l.memory), "burst": nil})
+}
+
+func TestEncode_JSONMarshaler(t *testing.T) {
+	in := struct {
+		Limits JSONLimits      `hcl:"limits"`
+		Raw    json.RawMessage `hcl:"raw"`
+	}{JSONLimits{2, 512}, json.RawMessage(`[1, 2.5, 123456789012345678901234567890]`)}
+
+	b, err := Encode(in, WithJSONMarshaler())
+	assert.NoError(t, err)
+	assert.Equal(t, `limits {
+  cpu    = 2
+  memory = "512Mi"
+}
+
+raw = [
+  1,
+  2.5,
+  123456789012345678901234567890,
+]
+`, string(b))
+
+	// without the option, the struct is encoded as is
+	b, err = Encode(in)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `raw = "[1, 2.5, 123456789012345678901234567890]"`)
+}
+
+func TestDecode_Error(t *testing.T) {
+	var out RoundTripConfig
+	assert.Error(t, Decode([]byte("name = {"), &out))
+}
+
+func TestEncoder_DrainLimit(t *testing.T) {
+	enc := NewEncoder(ioutil.Discard)
+	enc.DrainChannels = true
+
+	ch := make(chan int, MaxDrainCount+1)
+	for i := 0; i < MaxDrainCount; i++ {
+		ch <- i
+	}
+	close(ch)
+	assert.NoError(t, enc.Encode(map[string]chan int{"ch": ch}))
+
+	ch = make(chan int, MaxDrainCount+1)
+	for i := 0; i <= MaxDrainCount; i++ {
+		ch <- i
+	}
+	assert.EqualError(t, enc.Encode(map[string]chan int{"ch": ch}), `field "ch": channel chan int exceeded 10000 values, is it closed?`)
+	assert.Equal(t, 1, len(ch))
+
+	ch = make(chan int, MaxDrainCount)
+	for i := 0; i < MaxDrainCount; i++ {
+		ch <- i
+	}
+	assert.Error(t, enc.Encode(map[string]chan int{"ch": ch}))
+}
+
+func TestEncode_QuotedMapKeyOrder(t *testing.T) {
+	out, err := Encode(map[string]int{"zeta": 1, "for": 2, "alpha": 3, "a b": 4})
+	assert.NoError(t, err)
+	assert.Equal(t, "\"a b\" = 4\n\nalpha = 3\n\n\"for\" = 2\n\nzeta = 1\n", string(out))
+}
+
+func TestEncode_RetainedNodes(t *testing.T) {
+	type Service struct {
+		Name string `hcl:",key"`
+		Port int    `hcl:"port"`
+	}
+	in := struct {
+		Services []Service `hcl:"svc"`
+	}{[]Service{{"web", 80}}}
+
+	var kept *ast.ObjectItem
+	enc := NewEncoder(ioutil.Discard)
+	enc.OnBlock = func(path string, block *ast.ObjectItem) { kept = block }
+	assert.NoError(t, enc.Encode(in))
+
+	// nodes retained from a previous call must not be reused by later ones
+	for i := 0; i < 10; i++ {
+		_, err := Encode(map[string]interface{}{"x": "zzz", "y": []int{1, 2, 3}})
+		assert.NoError(t, err)
+	}
+
+	port := kept.Val.(*ast.ObjectType).List.Items[0].Val.(*ast.LiteralType)
+	assert.Equal(t, token.NUMBER, port.Token.Type)
+	assert.Equal(t, "80", port.Token.Text)
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.Run("formatted", func(b *testing.B) { benchmarkEncode(b) })
+	b.Run("unformatted", func(b *testing.B) { benchmarkEncode(b, WithoutFormatting()) })
+}
+
+// BenchmarkEncode_StructSlice measures the encoding of many values of the same
+// struct type, excluding printing.
+func BenchmarkEncode_StructSlice(b *testing.B) {
+	in := make([]RoundTripConfig, 10000)
+	for i := range in {
+		in[i].Name = fmt.Sprintf("farm-%d", i)
+		in[i].Acres = float64(i)
+	}
+	v := reflect.ValueOf(in)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := new(Encoder).encode(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkEncode(b *testing.B, opts ...Option) {
+	widgets := make([]ChanWidget, 100)
+	for i := range widgets {
+		widgets[i] = ChanWidget{Name: fmt.Sprintf("widget-%d", i), Size: i}
+	}
+	in := struct {
+		Widgets []ChanWidget `hcl:"widget"`
+	}{widgets}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Encode(in, opts...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncoder_OnBlock(t *testing.T) {
+	type Animal struct {
+		Name  string `hcl:",key"`
+		Sound string `hcl:"says"`
+	}
+
+	input := struct {
+		Name      string            `hcl:"name"`
+		Farmer    struct{ Age int } `hcl:"farmer"`
+		Animals   []Animal          `hcl:"animal"`
+		Buildings map[string]string `hcl:"buildings"`
+	}{
+		Name:      "Ol' McDonald's Farm",
+		Animals:   []Animal{{"cow", "moo"}, {"pig", "oink"}},
+		Buildings: map[string]string{"Barn": "456 Digits Drive"},
+	}
+
+	var paths []string
+	enc := NewEncoder(ioutil.Discard)
+	enc.OnBlock = func(path string, block *ast.ObjectItem) {
+		paths = append(paths, path)
+	}
+
+	assert.NoError(t, enc.Encode(input))
+	assert.Equal(t, []string{"farmer", "animal.cow", "animal.pig", "buildings"}, paths)
+}
+
+func TestEncode_Options(t *testing.T) {
+	in := struct {
+		Tags   []string   `hcl:"tags"`
+		Widget ChanWidget `hcl:"widget"`
+	}{[]string{"a", "b"}, ChanWidget{"x", 1}}
+
+	out, err := Encode(in, WithIndent(4))
+	assert.NoError(t, err)
+	assert.Equal(t, "tags = [\n    \"a\",\n    \"b\",\n]\n\nwidget \"x\" {\n    size = 1\n}\n", string(out))
+
+	tagged := struct {
+		Name string `hcl:"ignored" hclenc:"name"`
+	}{"foo"}
+
+	out, err = Encode(tagged, WithTagName("hclenc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "name = \"foo\"\n", string(out))
+
+	b := &bytes.Buffer{}
+	assert.NoError(t, NewEncoder(b).Encode(in))
+	out, err = Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, b.String(), string(out))
+}
+
+func TestEncode_CommentStyle(t *testing.T) {
+	in := struct {
+		Region string   `hcl:"region" hcle:"comment:The deployment region,inlinecomment:primary"`
+		Zones  []string `hcl:"zones"`
+	}{"us-east-1", []string{"a"}}
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "# The deployment region\nregion = \"us-east-1\" # primary\n\nzones = [\"a\"]\n", string(out))
+
+	out, err = Encode(in, WithCommentStyle(SlashComment))
+	assert.NoError(t, err)
+	assert.Equal(t, "// The deployment region\nregion = \"us-east-1\" // primary\n\nzones = [\"a\"]\n", string(out))
+
+	b := &bytes.Buffer{}
+	enc := NewEncoder(b, WithCommentStyle(SlashComment))
+	enc.Header = []string{"generated"}
+	enc.ListIndexComments = true
+	assert.NoError(t, enc.Encode(in))
+	assert.Equal(t, "// generated\n\n// The deployment region\nregion = \"us-east-1\" // primary\n\nzones = [\n  \"a\", // 0\n]\n", b.String())
+
+	_, err = Encode(in, WithCommentStyle(";"))
+	assert.EqualError(t, err, `unsupported comment style ";"`)
+}
+
+func TestEncode_JSONMapKeys(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	in := map[string]map[point]string{"labels": {{1, 2}: "a", {0, 5}: "b"}}
+
+	_, err := Encode(in)
+	assert.EqualError(t, err, `field "labels": map keys must be strings, struct given`)
+
+	out, err := Encode(in, WithJSONMapKeys())
+	assert.NoError(t, err)
+	assert.Equal(t, "labels {\n  \"{\\\"x\\\":0,\\\"y\\\":5}\" = \"b\"\n  \"{\\\"x\\\":1,\\\"y\\\":2}\" = \"a\"\n}\n", string(out))
+
+	type hidden struct {
+		X int `json:"-"`
+	}
+	_, err = Encode(map[hidden]int{{1}: 1, {2}: 2}, WithJSONMapKeys())
+	assert.EqualError(t, err, "duplicate JSON map key {}")
+}
+
+func TestEncode_ListInlineMaxLen(t *testing.T) {
+	in := struct {
+		Below []int    `hcl:"below"`
+		At    []string `hcl:"at"`
+		Above []int    `hcl:"above"`
+	}{
+		Below: []int{1, 2},
+		At:    []string{"a", "b", "c"},
+		Above: []int{1, 2, 3, 4},
+	}
+
+	b := &bytes.Buffer{}
+	enc := NewEncoder(b)
+	enc.ListInlineMaxLen = 3
+	assert.NoError(t, enc.Encode(in))
+	assert.Equal(t, "below = [1, 2]\n\nat = [\"a\", \"b\", \"c\"]\n\nabove = [\n  1,\n  2,\n  3,\n  4,\n]\n", b.String())
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "below = [\n  1,\n  2,\n]\n\nat = [\n  \"a\",\n  \"b\",\n  \"c\",\n]\n\nabove = [\n  1,\n  2,\n  3,\n  4,\n]\n", string(out))
+
+	b.Reset()
+	enc.ListIndexComments = true
+	assert.NoError(t, enc.Encode(map[string][]int{"ports": {80, 443}}))
+	assert.Equal(t, "ports = [\n  80,  # 0\n  443, # 1\n]\n", b.String())
+}
+
+func TestEncodeMultiFile(t *testing.T) {
+	in := struct {
+		Name    string       `hcl:"name"`
+		Widgets []ChanWidget `hcl:"widget,file=widgets.hcl"`
+		Nested  ChanWidget   `hcl:"nested,file=nested.hcl"`
+		Tags    []string     `hcl:"tags,file=main.hcl"`
+	}{
+		Name:    "foo",
+		Widgets: []ChanWidget{{"a", 1}, {"b", 2}},
+		Nested:  ChanWidget{"c", 3},
+		Tags:    []string{"x"},
+	}
+
+	files, err := EncodeMultiFile(in)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		MainFile:      []byte("name = \"foo\"\n\ntags = [\"x\"]\n"),
+		"widgets.hcl": []byte("widget \"a\" {\n  size = 1\n}\n\nwidget \"b\" {\n  size = 2\n}\n"),
+		"nested.hcl":  []byte("nested \"c\" {\n  size = 3\n}\n"),
+	}, files)
+
+	_, err = EncodeMultiFile([]string{"foo"})
+	assert.Error(t, err)
+}
+
+func TestEncode_WithoutFormatting(t *testing.T) {
+	out, err := Encode(spacingInput, WithoutFormatting())
+	assert.NoError(t, err)
+	assert.Equal(t, "name = \"foo\"\ntags = [\"a\", \"b\"]\nwidget \"a\" {\nsize = 1\n}\nwidget \"b\" {\nsize = 2\n}\nnested {\nx = \"y\"\n}\n", string(out))
+
+	b := &bytes.Buffer{}
+	enc := NewEncoder(b, WithoutFormatting())
+	enc.ListIndexComments = true
+	assert.NoError(t, enc.Encode(map[string][]int{"ports": {80, 443}}))
+	assert.Equal(t, "ports = [\n80, # 0\n443, # 1\n]\n", b.String())
+
+	var v interface{}
+	assert.NoError(t, hcl.Unmarshal(b.Bytes(), &v))
+}
+
+func TestEncodeTyped(t *testing.T) {
+	expected, err := Encode(spacingInput, WithCompact())
+	assert.NoError(t, err)
+
+	out, err := EncodeTyped(spacingInput, WithCompact())
+	assert.NoError(t, err)
+	assert.Equal(t, expected, out)
+
+	_, err = EncodeTyped[*ChanWidget](nil)
+	assert.Error(t, err)
+}
+
+func TestEncode_Compact(t *testing.T) {
+	expected, err := ioutil.ReadFile("_tests/block-spacing-none.hcl")
+	assert.NoError(t, err)
+
+	out, err := Encode(spacingInput, WithCompact())
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(out))
+}
+
+// All output passes through the HCL printer, so there is no unformatted mode;
+// this pins the spacing it produces around operators and separators.
+func TestEncode_Spacing(t *testing.T) {
+	in := map[string]interface{}{
+		"list":   []int{1},
+		"object": []interface{}{map[string]interface{}{"x": 1, "yy": "z"}},
+	}
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "list = [1]\n\nobject = [\n  {\n    x  = 1\n    yy = \"z\"\n  },\n]\n", string(out))
+}
+
+func TestEncode_RawNodeNotModified(t *testing.T) {
+	call := &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}}
+
+	for i := 0; i < 2; i++ {
+		out, err := Encode(map[string]interface{}{"key": call})
+		assert.NoError(t, err)
+		assert.Equal(t, "key = file(\"id_rsa.pub\")\n", string(out))
+	}
+	assert.Equal(t, &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}}, call)
+}
+
+func TestEncode_RawEmptyObject(t *testing.T) {
+	in := struct {
+		Raw ast.Node `hcl:"raw"`
+	}{&ast.ObjectType{}}
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "raw {}\n", string(out))
+}
+
+func TestEncode_BoolsInObjectLiterals(t *testing.T) {
+	in := map[string][]ToggleStruct{"toggles": {{Enabled: true}}}
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "toggles = [\n  {\n    enabled = true\n    debug   = false\n  },\n]\n", string(out))
+
+	var decoded map[string][]ToggleStruct
+	assert.NoError(t, Decode(out, &decoded))
+	assert.Equal(t, in, decoded)
+}
+
+func TestEncode_TrailingKeyField(t *testing.T) {
+	out, err := Encode(map[string][]TrailingKeyStruct{"widget": {{Foo: "bar", Baz: 1, Name: "fizz"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "widget \"fizz\" {\n  foo = \"bar\"\n  baz = 1\n}\n", string(out))
+
+	out, err = Encode(map[string]*TrailingKeyStruct{"widget": {Foo: "bar", Baz: 1, Name: "fizz"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "widget \"fizz\" {\n  foo = \"bar\"\n  baz = 1\n}\n", string(out))
+}
+
+func TestEncode_Nil(t *testing.T) {
+	var ptr *TestStruct
+
+	for _, in := range []interface{}{nil, ptr} {
+		out, err := Encode(in)
+		assert.EqualError(t, err, "cannot encode nil value to HCL")
+		assert.Nil(t, out)
+	}
+}
+
+func TestEncoder_UnsupportedKindError(t *testing.T) {
+	_, err := Encode(make(chan int))
+	assert.EqualError(t, err, "cannot encode chan int of kind chan to HCL")
+}
+
+func TestEncode_URL(t *testing.T) {
+	out, err := Encode(URLStruct{})
+	assert.NoError(t, err)
+	assert.Equal(t, "\n", string(out))
+
+	in := URLStruct{
+		Endpoint: *mustParseURL("https://user@example.com:8443/api?v=2#top"),
+		Proxy:    mustParseURL("http://proxy.local:3128"),
+	}
+	out, err = Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "endpoint = \"https://user@example.com:8443/api?v=2#top\"\n\nproxy = \"http://proxy.local:3128\"\n", string(out))
+
+	var decoded struct {
+		Endpoint string `hcl:"endpoint"`
+		Proxy    string `hcl:"proxy"`
+	}
+	assert.NoError(t, Decode(out, &decoded))
+	assert.Equal(t, in.Endpoint.String(), mustParseURL(decoded.Endpoint).String())
+	assert.Equal(t, in.Proxy.String(), mustParseURL(decoded.Proxy).String())
+}
+
+func TestEncode_Cycle(t *testing.T) {
+	a := &CycleNode{Name: "a"}
+	b := &CycleNode{Name: "b"}
+	c := &CycleNode{Name: "c"}
+	a.Next, b.Next, c.Next = b, c, a
+
+	_, err := Encode(a)
+	assert.EqualError(t, err, `field "next.next.next": cycle detected: refers back to the root value`)
+
+	m := map[string]interface{}{"nodes": []interface{}{a}}
+	_, err = Encode(m)
+	assert.EqualError(t, err, `field "nodes[0].next.next.next": cycle detected: refers back to "nodes[0]"`)
+
+	shared := &CycleNode{Name: "shared"}
+	out, err := Encode(map[string]*CycleNode{"x": shared, "y": shared})
+	assert.NoError(t, err)
+	assert.Equal(t, "x {\n  Name = \"shared\"\n}\n\ny {\n  Name = \"shared\"\n}\n", string(out))
+
+	type first struct {
+		Name string `hcl:"name"`
+	}
+	type outer struct {
+		First first  `hcl:"first"`
+		Alias *first `hcl:"alias"`
+	}
+	o := &outer{First: first{"x"}}
+	o.Alias = &o.First
+	out, err = Encode(o)
+	assert.NoError(t, err)
+	assert.Equal(t, "first {\n  name = \"x\"\n}\n\nalias {\n  name = \"x\"\n}\n", string(out))
+
+	s := []interface{}{nil}
+	s[0] = s
+	_, err = Encode(map[string]interface{}{"list": s})
+	assert.EqualError(t, err, `field "list[0]": cycle detected: refers back to "list"`)
+}
+
+func TestEncode_ErrorPath(t *testing.T) {
+	type container struct {
+		Name string      `hcl:",key"`
+		Port interface{} `hcl:"port"`
+	}
+	in := map[string]interface{}{
+		"spec": struct {
+			Containers []container `hcl:"containers"`
+		}{[]container{{"a", 80}, {"b", 443}, {"c", make(chan int)}}},
+	}
+
+	_, err := Encode(in)
+	assert.EqualError(t, err, `field "spec.containers[2].port": cannot encode chan int of kind chan to HCL`)
+
+	var pe *pathError
+	assert.True(t, errors.As(err, &pe))
+	assert.EqualError(t, errors.Unwrap(err), "cannot encode chan int of kind chan to HCL")
+}
+
+func TestEncode_NonFiniteFloat(t *testing.T) {
+	_, err := Encode(struct {
+		Ratio float64 `hcl:"ratio"`
+	}{math.NaN()})
+	assert.EqualError(t, err, `field "ratio": cannot encode non-finite float NaN to HCL`)
+
+	_, err = Encode(map[string][]float64{"weights": {1, math.Inf(-1)}})
+	assert.EqualError(t, err, `field "weights[1]": cannot encode non-finite float -Inf to HCL`)
+}
+
+func TestEncode_HeredocRoundTrip(t *testing.T) {
+	in := struct {
+		Script string `hcl:"script" hcle:"heredoc"`
+	}{"#!/bin/sh\necho \"${var.greeting}\"\n"}
+
+	out, err := Encode(in)
+	assert.NoError(t, err)
+	assert.Equal(t, "script = <<EOF\n#!/bin/sh\necho \"${var.greeting}\"\nEOF\n", string(out))
+
+	var decoded struct {
+		Script string `hcl:"script"`
+	}
+	assert.NoError(t, Decode(out, &decoded))
+	assert.Equal(t, in.Script, decoded.Script)
+
+	out, err = Encode(struct {
+		Script string `hcl:"script" hcle:"heredoc,literal"`
+	}{"echo ${HOME}"})
+	assert.NoError(t, err)
+	assert.Equal(t, "script = <<EOF\necho $${HOME}\nEOF\n", string(out))
+
+	_, err = Encode(struct {
+		Count int `hcle:"heredoc"`
+	}{})
+	assert.EqualError(t, err, `field "Count": heredoc cannot be applied to kind int`)
+}
+
+func TestEncode_DottedNameCollision(t *testing.T) {
+	_, err := Encode(struct {
+		Metadata struct{} `hcl:"metadata"`
+		Labels   []string `hcl:"metadata.labels"`
+	}{Labels: []string{"a"}})
+	assert.EqualError(t, err, `field "metadata.labels": prefix "metadata" is also the key of another field`)
+
+	_, err = Encode(struct {
+		Labels   []string `hcl:"metadata.labels"`
+		Metadata struct{} `hcl:"metadata"`
+	}{Labels: []string{"a"}})
+	assert.EqualError(t, err, `field "metadata": key "metadata" is also the prefix of a dotted field name`)
+}
+
+func TestEncoder_WriteError(t *testing.T) {
+	err := NewEncoder(errWriter{}).Encode(TestStruct{"foo"})
+	assert.EqualError(t, err, "write failed")
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestSpaceBlocks(t *testing.T) {
+	in := "# header\nfoo = 1\n\n# comment\nbar {\n  a {}\n\n  b {}\n}\nbaz = 2\n"
+	expected := "# header\nfoo = 1\n\n\n# comment\nbar {\n  a {}\n\n  b {}\n}\n\n\nbaz = 2\n"
+	assert.Equal(t, expected, string(spaceBlocks([]byte(in), 2)))
+}
+
+func TestSingleSpaceEquals(t *testing.T) {
+	in := "a    = 1\nbb   = \"x  = y\"\n\"c d\" = 2\nnested {\n  e  = f == g\n  ff = <<EOF\nh   = i\nEOF\n}\n# j   = k\n"
+	expected := "a = 1\nbb = \"x  = y\"\n\"c d\" = 2\nnested {\n  e = f == g\n  ff = <<EOF\nh   = i\nEOF\n}\n# j   = k\n"
+	assert.Equal(t, expected, string(singleSpaceEquals([]byte(in))))
+
+	b := &bytes.Buffer{}
+	enc := NewEncoder(b)
+	enc.SingleSpaceEquals = true
+	assert.NoError(t, enc.Encode(map[string]interface{}{
+		"nested": map[string]interface{}{"a": 1, "bbb": "x"},
+	}))
+	assert.Equal(t, "nested {\n  a = 1\n  bbb = \"x\"\n}\n", b.String())
+}
+
+func TestWrapComments(t *testing.T) {
+	assert.Equal(t, "foo = 1\n", string(wrapComments([]byte("foo = 1\n"), HashComment, nil, nil)))
+	assert.Equal(t, "# head\n\nfoo = 1\n", string(wrapComments([]byte("foo = 1\n"), HashComment, []string{"head"}, nil)))
+	assert.Equal(t, "foo = 1\n\n# foot\n", string(wrapComments([]byte("foo = 1\n"), HashComment, nil, []string{"foot"})))
+	assert.Equal(t, "# head\n#\n# more\n", string(wrapComments([]byte("\n"), HashComment, []string{"head", "", "more"}, nil)))
+	assert.Equal(t, "// head\n\nfoo = 1\n", string(wrapComments([]byte("foo = 1\n"), SlashComment, []string{"head"}, nil)))
+}
+
+var spacingInput = struct {
+	Name    string            `hcl:"name"`
+	Tags    []string          `hcl:"tags"`
+	Widgets []ChanWidget      `hcl:"widget"`
+	Nested  map[string]string `hcl:"nested"`
+}{
+	Name:    "foo",
+	Tags:    []string{"a", "b"},
+	Widgets: []ChanWidget{{"a", 1}, {"b", 2}},
+	Nested:  map[string]string{"x": "y"},
+}
+
+var heredocInput = struct {
+	Script string       `hcl:"script" hcle:"heredoc"`
+	Steps  []string     `hcl:"steps" hcle:"heredoc"`
+	Widget []ChanWidget `hcl:"widget"`
+}{
+	Script: "set -e\n\nname = ${var.name}\n\n\nexit 0\n",
+	Steps:  []string{"make\n\nmake test\n"},
+	Widget: []ChanWidget{{"a", 1}},
+}
+
+var commentInput = struct {
+	Region  string       `hcl:"region" hcle:"comment:The deployment region"`
+	Zones   []string     `hcl:"zones" hcle:"comment:Availability zones\nin preference order"`
+	Widgets []ChanWidget `hcl:"widget" hcle:"comment:Widgets\\, keyed by name"`
+	Nested  struct {
+		Size int `hcl:"size" hcle:"comment:Size: in bytes"`
+	} `hcl:"nested"`
+	Skipped string `hcle:"omitempty,comment:Never shown"`
+}{
+	Region:  "us-east-1",
+	Zones:   []string{"a", "b"},
+	Widgets: []ChanWidget{{"a", 1}, {"b", 2}},
+}
+
+var inlineCommentInput = struct {
+	Region  string            `hcl:"region" hcle:"inlinecomment:primary"`
+	Zones   []string          `hcl:"zones" hcle:"inlinecomment:preferred first"`
+	Widgets []ChanWidget      `hcl:"widget" hcle:"inlinecomment:ignored"`
+	Nested  map[string]string `hcl:"nested" hcle:"inlinecomment:ignored"`
+}{
+	Region:  "us-east-1",
+	Zones:   []string{"a", "b"},
+	Widgets: []ChanWidget{{"a", 1}},
+	Nested:  map[string]string{"x": "y"},
+}
+
+func jsonMap(s string) map[string]interface{} {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+type DynamicIngress struct {
+	Port     Expr   `hcl:"from_port"`
+	Protocol string `hcl:"protocol"`
+}
+
+type ChanWidget struct {
+	Name string `hcl:",key"`
+	Size int    `hcl:"size"`
+}
+
+func testChan(vals ...ChanWidget) chan ChanWidget {
+	ch := make(chan ChanWidget, len(vals))
+	for _, v := range vals {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+type CycleNode struct {
+	Name string
+	Next *CycleNode `hcl:"next"`
+}
+
+type URLStruct struct {
+	Endpoint url.URL  `hcl:"endpoint" hcle:"omitempty"`
+	Proxy    *url.URL `hcl:"proxy"`
+}
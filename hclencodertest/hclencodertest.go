@@ -0,0 +1,43 @@
+// Package hclencodertest provides utilities for testing that values survive
+// encoding to HCL with hclencoder and decoding back.
+package hclencodertest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rodaine/hclencoder"
+)
+
+// AssertRoundTrip encodes in with opts, decodes the output into a new value of
+// the same type, and fails t if the decoded value is not deeply equal to in.
+// Fields tagged with hcle:"omit" are never encoded, so they should be left
+// zero in in.
+func AssertRoundTrip(t testing.TB, in interface{}, opts ...hclencoder.Option) {
+	t.Helper()
+
+	b, err := hclencoder.Encode(in, opts...)
+	if err != nil {
+		t.Fatalf("encoding %T: %v", in, err)
+		return
+	}
+
+	typ := reflect.TypeOf(in)
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+
+	out := reflect.New(typ)
+	if err = hclencoder.Decode(b, out.Interface()); err != nil {
+		t.Fatalf("decoding %T: %v\n%s", in, err, b)
+		return
+	}
+
+	if !ptr {
+		out = out.Elem()
+	}
+	if actual := out.Interface(); !reflect.DeepEqual(in, actual) {
+		t.Errorf("%T did not round trip through HCL:\n%s\nexpected: %#v\nactual:   %#v", in, b, in, actual)
+	}
+}
@@ -0,0 +1,64 @@
+package hclencodertest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Animal struct {
+	Name  string `hcl:",key"`
+	Sound string `hcl:"says"`
+}
+
+type Farm struct {
+	Name      string            `hcl:"name"`
+	Owned     bool              `hcl:"owned"`
+	Location  []float64         `hcl:"location"`
+	Animals   []Animal          `hcl:"animal"`
+	Buildings map[string]string `hcl:"buildings"`
+	Secret    string            `hcle:"omit"`
+}
+
+// recorder is a testing.TB that records failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	farm := Farm{
+		Name:      "Ol' McDonald's Farm",
+		Owned:     true,
+		Location:  []float64{12.34, -5.67},
+		Animals:   []Animal{{"cow", "moo"}, {"pig", "oink"}},
+		Buildings: map[string]string{"Barn": "456 Digits Drive"},
+	}
+
+	AssertRoundTrip(t, farm)
+	AssertRoundTrip(t, &farm)
+
+	r := &recorder{TB: t}
+	farm.Secret = "please-dont-share-me"
+	AssertRoundTrip(r, farm)
+	if assert.Len(t, r.failures, 1) {
+		assert.Contains(t, r.failures[0], "hclencodertest.Farm did not round trip")
+	}
+
+	r = &recorder{TB: t}
+	AssertRoundTrip(r, []string{"a"})
+	if assert.Len(t, r.failures, 1) {
+		assert.Contains(t, r.failures[0], "decoding []string")
+	}
+}
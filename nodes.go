@@ -1,12 +1,26 @@
 package hclencoder
 
 import (
+	"bytes"
+	"database/sql/driver"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/hashicorp/hcl/hcl/token"
@@ -27,6 +41,36 @@ const (
 	// the key for the value.
 	SquashTag string = "squash"
 
+	// ExprTag indicates that the string value of the field should be emitted
+	// verbatim as an HCL expression rather than a quoted string. This is
+	// equivalent to the field being of type Expr.
+	ExprTag string = "expr"
+
+	// DynamicTag indicates that the struct value of the field, or the single
+	// element of a slice of structs, should be emitted as a Terraform dynamic
+	// block with the value as its content. The ForEachTag directive is
+	// required.
+	DynamicTag string = "dynamic"
+
+	// BlocksTag indicates that the map of structs value of the field should be
+	// emitted as blocks labeled by their map keys (eg, `server "web" {}`)
+	// rather than as an object.
+	BlocksTag string = "blocks"
+
+	// SetTag indicates that the map[T]struct{} value of the field should be
+	// emitted as a sorted list of its keys rather than an object.
+	SetTag string = "set"
+
+	// ObjectTag indicates that the slice of key-value pairs value of the
+	// field should be emitted as an object, in the order of the slice. The
+	// elements must be structs with a string Key field and a Value field.
+	ObjectTag string = "object"
+
+	// FileTag indicates that EncodeMultiFile should emit the field into the
+	// named output instead of MainFile (eg, `hcl:"network,file=network.hcl"`).
+	// It has no effect on other encoding functions or nested structs.
+	FileTag string = "file"
+
 	// UnusedKeysTag is a flag that indicates any unused keys found by the
 	// decoder are stored in this field of type []string. This has the same
 	// behavior as the OmitTag and is not encoded.
@@ -48,6 +92,114 @@ const (
 	// OmitEmptyTag will omit this field if it is a zero value. This
 	// is similar behavior to `json:",omitempty"`
 	OmitEmptyTag string = "omitempty"
+
+	// UnitTag is a directive that renders a numeric field as a string with
+	// the provided unit appended (eg, `hcle:"unit:Mi"` encodes 512 as
+	// "512Mi"). Applies to each element of a list.
+	UnitTag string = "unit"
+
+	// PrecisionTag is a directive that renders a float field with a fixed
+	// number of decimal places (eg, `hcle:"precision:2"` encodes 4.5 as
+	// 4.50). Applies to each element of a list. A big.Rat field is rendered
+	// as a decimal string instead of a fraction.
+	PrecisionTag string = "precision"
+
+	// Base64Tag is a flag that encodes a byte slice or array field as a base64
+	// string. Otherwise, the bytes are encoded as a raw UTF-8 string.
+	Base64Tag string = "base64"
+
+	// FormatDateTag is a directive that encodes a time.Time field as a call to
+	// the Terraform formatdate function with the given spec and the RFC 3339
+	// time (eg, `hcle:"formatdate:DD MMM YYYY"` emits `formatdate("DD MMM
+	// YYYY", "2020-01-02T03:04:05Z")`).
+	FormatDateTag string = "formatdate"
+
+	// CommentTag is a directive that emits a comment before the field (eg,
+	// `hcle:"comment:The deployment region"`). Newlines in the comment
+	// produce multiple comment lines. Commas must be escaped with a
+	// backslash (eg, `hcle:"comment:Region\\, zone"`).
+	CommentTag string = "comment"
+
+	// InlineCommentTag is a directive that emits a comment on the same line
+	// as the field's value (eg, `hcle:"inlinecomment:primary"`). It has no
+	// effect on block-valued fields.
+	InlineCommentTag string = "inlinecomment"
+
+	// SplitTag is a directive that lifts the entry with the given key out of
+	// a map field, emitting it alongside the field instead of within it (eg,
+	// `hcle:"split:logging"`).
+	SplitTag string = "split"
+
+	// PresenceTag is a directive that encodes a bool field as an empty block
+	// when true, and omits it when false (eg, `feature {}`).
+	PresenceTag string = "presence"
+
+	// KeepNilTag is a directive that encodes a nil field as null instead of
+	// omitting it. OmitEmptyTag takes precedence.
+	KeepNilTag string = "keepnil"
+
+	// WithZoneTag is a directive that encodes a time.Time field as an object
+	// holding both the time and the name of its location (eg, `{ time =
+	// "2020-01-02T03:04:05-05:00", zone = "America/New_York" }`).
+	WithZoneTag string = "withzone"
+
+	// LiteralTag is a directive that escapes interpolation sequences in the
+	// string value of the field (eg, "${x}" is emitted as "$${x}"), so they
+	// are not evaluated. This is the inverse of ExprTag.
+	LiteralTag string = "literal"
+
+	// HeredocTag is a directive that emits the string value of the field as a
+	// heredoc (eg, `<<EOF`), in which interpolation sequences remain active.
+	// A trailing newline is added to the value if not present.
+	HeredocTag string = "heredoc"
+
+	// SectionTag is a directive that groups the field with the other fields of
+	// the same section, emitted after the fields without a section under a
+	// comment header (eg, `hcle:"section:Networking"` emits `# Networking`).
+	// Sections are ordered by their first field.
+	SectionTag string = "section"
+
+	// SensitiveTag is a directive that replaces the value of the field with
+	// Encoder.RedactedText, or omits the field if Encoder.OmitSensitive is
+	// set. Each value within a sensitive list, map, or struct is redacted,
+	// except for the key fields of blocks.
+	SensitiveTag string = "sensitive"
+
+	// StringerTag is a directive that emits the value of the field (or each
+	// value of a list or map) as the string returned by its String method,
+	// such as the name of an enum value, instead of its underlying value. The
+	// value must implement fmt.Stringer.
+	StringerTag string = "stringer"
+
+	// ConcatTag is a directive that emits a slice of lists as a call to the
+	// concat function (eg, `concat(["a"], ["b", "c"])`) instead of a list of
+	// lists. Elements may also be expressions evaluating to lists.
+	ConcatTag string = "concat"
+
+	// InheritLabelsTag is a directive that prepends the labels of the
+	// enclosing block to the labels of the block encoded from the field (eg,
+	// `service "web" { check "web" {} }`), so they need not be repeated in
+	// the child value.
+	InheritLabelsTag string = "inheritlabels"
+
+	// AutoBoolTag is a directive that emits the string value of the field as
+	// a bool if it is exactly "true" or "false", and as a string otherwise.
+	// This eases the migration of configs holding bools as strings.
+	AutoBoolTag string = "autobool"
+
+	// ToSetTag and ToListTag are directives that wrap the list value of the
+	// field in a call to the toset or tolist type conversion function (eg,
+	// `toset(["a", "b"])`). The list elements must be primitives.
+	ToSetTag  string = "toset"
+	ToListTag string = "tolist"
+
+	// ForEachTag is a directive providing the for_each expression of a
+	// dynamic block (eg, `hcle:"foreach:var.ingress_rules"`).
+	ForEachTag string = "foreach"
+
+	// MaxDrainCount is the maximum number of values received from a single
+	// channel when Encoder.DrainChannels is enabled.
+	MaxDrainCount = 10000
 )
 
 type fieldMeta struct {
@@ -59,51 +211,529 @@ type fieldMeta struct {
 	decodedFields bool
 	omit          bool
 	omitEmpty     bool
+	unit          string
+	expr          bool
+	precision     int
+	hasPrecision  bool
+	base64        bool
+	formatDate    string
+	comment       string
+	inlineComment string
+	split         string
+	presence      bool
+	dynamic       bool
+	set           bool
+	blocks        bool
+	object        bool
+	forEach       string
+	file          string
+	keepNil       bool
+	withZone      bool
+	literal       bool
+	heredoc       bool
+	autoBool      bool
+	inheritLabels bool
+	concat        bool
+	stringer      bool
+	sensitive     bool
+	section       string
+	convert       string
 }
 
+// fileName returns the name of the EncodeMultiFile output holding the field.
+func (m fieldMeta) fileName() string {
+	if m.file == "" {
+		return MainFile
+	}
+	return m.file
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	exprType          = reflect.TypeOf(Expr(""))
+	ratType           = reflect.TypeOf(big.Rat{})
+	bigIntType        = reflect.TypeOf(big.Int{})
+	bigFloatType      = reflect.TypeOf(big.Float{})
+	nodeType          = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	ipNetType         = reflect.TypeOf(net.IPNet{})
+	urlType           = reflect.TypeOf(url.URL{})
+	regexpType        = reflect.TypeOf(regexp.Regexp{})
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
 // encode converts a reflected valued into an HCL ast.Node in a depth-first manner.
-func encode(in reflect.Value) (node ast.Node, key []*ast.ObjectKey, err error) {
+func (e *Encoder) encode(in reflect.Value) (node ast.Node, key []*ast.ObjectKey, err error) {
+	return e.encodeField(in, fieldMeta{})
+}
+
+// encodeField behaves like encode, additionally applying any formatting
+// specified by the tags of the struct field the value originated from.
+func (e *Encoder) encodeField(in reflect.Value, meta fieldMeta) (node ast.Node, key []*ast.ObjectKey, err error) {
+	if meta.sensitive && !e.redacting {
+		e.redacting = true
+		defer func() { e.redacting = false }()
+	}
+	if e.redacting && !meta.key {
+		defer func() {
+			if node != nil && err == nil {
+				tkn, _ := tokenize(reflect.ValueOf(e.redactedText()), false) // impossible to not be string
+				redactNode(node, tkn)
+			}
+		}()
+	}
+
+	if ref, ok := reference(in); ok {
+		if err = e.enter(ref); err != nil {
+			return nil, nil, err
+		}
+		defer e.leave(ref)
+	}
+
 	in, isNil := deref(in)
 	if isNil {
 		return nil, nil, nil
 	}
 
+	if in.Type() == exprType || meta.expr && in.Kind() == reflect.String {
+		return encodeExpr(in.String())
+	}
+
+	// the special cases below need the value as an interface, which is not
+	// available from unexported fields, so those are encoded by their kind
+	if in.CanInterface() && reflect.PtrTo(in.Type()).Implements(nodeType) {
+		return encodeNode(addr(in).Interface().(ast.Node))
+	}
+
+	if in.CanInterface() && in.Type() == ratType {
+		return encodeRat(addr(in).Interface().(*big.Rat), meta)
+	}
+
+	if meta.formatDate != "" {
+		switch {
+		case in.CanInterface() && in.Type() == timeType:
+			return encodeFormatDate(in.Interface().(time.Time), meta.formatDate)
+		case in.Kind() != reflect.Slice && in.Kind() != reflect.Map:
+			return nil, nil, fmt.Errorf("%s cannot be applied to %s", FormatDateTag, in.Type())
+		}
+	}
+
+	if in.CanInterface() && in.Type() == bigIntType {
+		return newLiteral(token.Token{Type: token.NUMBER, Text: addr(in).Interface().(*big.Int).String()}), nil, nil
+	}
+
+	if in.CanInterface() && in.Type() == bigFloatType {
+		return encodeBigFloat(addr(in).Interface().(*big.Float), meta)
+	}
+
+	if in.CanInterface() && (isStringerType(in.Type()) || meta.stringer && reflect.PtrTo(in.Type()).Implements(stringerType)) {
+		return encodeStringer(addr(in).Interface().(fmt.Stringer))
+	}
+
+	if in.CanInterface() && in.Type() == timeType && meta.withZone {
+		return encodeTimeWithZone(in.Interface().(time.Time))
+	}
+
+	if m, ok := textMarshaler(in); ok {
+		return encodeTextMarshaler(m)
+	}
+
+	if e.UseJSONMarshaler {
+		if m, ok := jsonMarshaler(in); ok {
+			return e.encodeJSONMarshaler(m, meta)
+		}
+	}
+
+	if in.CanInterface() && isSQLNullType(in.Type()) {
+		return e.encodeSQLNull(in.Interface().(driver.Valuer), meta)
+	}
+
+	if isBytes(in.Type()) {
+		return encodeBytes(in, meta)
+	}
+
 	switch in.Kind() {
 
 	case reflect.Bool, reflect.Float64, reflect.String,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return encodePrimitive(in)
+		return e.encodePrimitive(in, meta)
 
-	case reflect.Slice:
-		return encodeList(in)
+	case reflect.Slice, reflect.Array:
+		return e.encodeList(in, meta)
 
 	case reflect.Map:
-		return encodeMap(in)
+		if meta.set {
+			return e.encodeSet(in, meta)
+		}
+		if meta.blocks {
+			return e.encodeMapBlocks(in, meta)
+		}
+		return e.encodeMap(in, meta)
 
 	case reflect.Struct:
-		return encodeStruct(in)
+		return e.encodeStruct(in)
+
+	case reflect.UnsafePointer:
+		if e.SkipUnsupported {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("cannot encode %s to HCL, the memory it points to is untyped", in.Type())
+
+	case reflect.Chan:
+		if e.DrainChannels {
+			return e.encodeChan(in, meta)
+		}
+		fallthrough
 
 	default:
-		return nil, nil, fmt.Errorf("cannot encode kind %s to HCL", in.Kind())
+		if e.SkipUnsupported {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("cannot encode %s of kind %s to HCL", in.Type(), in.Kind())
+	}
+
+}
+
+// visitKey identifies a value being encoded for cycle detection. The type is
+// included as a struct and its first field share an address, and the length
+// as slices of the same array may differ.
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// reference returns the key of the pointer, map, or slice held by in,
+// unwrapping any interfaces, so that cycles can be detected.
+func reference(in reflect.Value) (visitKey, bool) {
+	for in.Kind() == reflect.Interface && !in.IsNil() {
+		in = in.Elem()
+	}
+
+	switch in.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if in.IsNil() {
+			return visitKey{}, false
+		}
+		return visitKey{ptr: in.Pointer(), typ: in.Type()}, true
+	case reflect.Slice:
+		if in.Len() == 0 {
+			return visitKey{}, false
+		}
+		return visitKey{ptr: in.Pointer(), typ: in.Type(), len: in.Len()}, true
+	default:
+		return visitKey{}, false
+	}
+}
+
+// enter marks the value at key as being encoded at the current path. An error
+// naming the path of the cycle is returned if the value is already being
+// encoded by an ancestor.
+func (e *Encoder) enter(key visitKey) error {
+	if depth, ok := e.visiting[key]; ok {
+		if depth == 0 {
+			return errors.New("cycle detected: refers back to the root value")
+		}
+		return fmt.Errorf("cycle detected: refers back to %q", formatPath(e.path[:depth]))
+	}
+	if e.visiting == nil {
+		e.visiting = make(map[visitKey]int)
+	}
+	e.visiting[key] = len(e.path)
+	return nil
+}
+
+// leave marks the value at key as no longer being encoded.
+func (e *Encoder) leave(key visitKey) {
+	delete(e.visiting, key)
+}
+
+// pathError is an error encountered while encoding the value at path.
+type pathError struct {
+	path string
+	err  error
+}
+
+func (e *pathError) Error() string { return fmt.Sprintf("field %q: %s", e.path, e.err) }
+func (e *pathError) Unwrap() error { return e.err }
+
+// wrapPath annotates err with the path of the value at seg, a child of the
+// value currently being encoded. Errors already annotated by a descendant are
+// returned unchanged, so the path of the innermost value is reported.
+func (e *Encoder) wrapPath(err error, seg string) error {
+	var pe *pathError
+	if errors.As(err, &pe) {
+		return err
 	}
+	path := make([]string, len(e.path), len(e.path)+1)
+	copy(path, e.path)
+	return &pathError{path: formatPath(append(path, seg)), err: err}
+}
 
+// formatPath joins the field names, map keys, and list indices of path into a
+// dot-separated string (eg, "foo.bar[0].baz").
+func formatPath(path []string) string {
+	b := &strings.Builder{}
+	for i, seg := range path {
+		if i > 0 && !strings.HasPrefix(seg, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
 }
 
 // encodePrimitive converts a primitive value into an ast.LiteralType. An
 // ast.ObjectKey is never returned.
-func encodePrimitive(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+func (e *Encoder) encodePrimitive(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	if meta.stringer {
+		return nil, nil, fmt.Errorf("%s cannot be applied to %s, which does not implement fmt.Stringer", StringerTag, in.Type())
+	}
+
 	tkn, err := tokenize(in, false)
 	if err != nil {
 		return nil, nil, err
 	}
+	// labels are not affected by the escaper, like keys
+	if tkn.Type == token.STRING && e.StringEscaper != nil && !meta.key {
+		tkn.Text = fmt.Sprintf(`"%s"`, e.StringEscaper(in.String()))
+	}
+
+	if meta.hasPrecision {
+		if tkn.Type != token.FLOAT {
+			return nil, nil, fmt.Errorf("precision cannot be applied to kind %s", in.Kind())
+		}
+		tkn.Text = strconv.FormatFloat(in.Float(), 'f', meta.precision, 64)
+	}
+
+	if meta.autoBool {
+		if tkn.Type != token.STRING {
+			return nil, nil, fmt.Errorf("autobool cannot be applied to kind %s", in.Kind())
+		}
+		if s := in.String(); s == "true" || s == "false" {
+			return newLiteral(token.Token{Type: token.BOOL, Text: s}), nil, nil
+		}
+	}
+
+	if meta.literal {
+		if tkn.Type != token.STRING {
+			return nil, nil, fmt.Errorf("literal cannot be applied to kind %s", in.Kind())
+		}
+		tkn.Text = fmt.Sprintf(`"%s"`, escapeLiteral(in.String(), e.stringEscaper()))
+	}
+
+	if meta.heredoc {
+		if tkn.Type != token.STRING {
+			return nil, nil, fmt.Errorf("heredoc cannot be applied to kind %s", in.Kind())
+		}
+		text := in.String()
+		if meta.literal {
+			text = strings.Replace(text, "${", "$${", -1)
+		}
+		tkn = heredoc(text)
+	}
+
+	if meta.unit != "" {
+		if tkn.Type != token.NUMBER && tkn.Type != token.FLOAT {
+			return nil, nil, fmt.Errorf("unit %q cannot be applied to kind %s", meta.unit, in.Kind())
+		}
+		tkn, _ = tokenize(reflect.ValueOf(tkn.Text+meta.unit), false) // impossible to not be string
+	}
+
+	return newLiteral(tkn), nil, nil
+}
+
+// encodeExpr converts an expression into an ast.LiteralType that is emitted
+// verbatim. An ast.ObjectKey is never returned.
+func encodeExpr(expr string) (ast.Node, []*ast.ObjectKey, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil, errors.New("expressions cannot be empty")
+	}
+
+	return newLiteral(token.Token{Type: token.IDENT, Text: expr}), nil, nil
+}
+
+// encodeNode copies a raw HCL ast.Node so that it is emitted verbatim. Literal,
+// list, and object values are supported, as well as object lists, which are
+// emitted as blocks. An ast.ObjectKey is never returned.
+func encodeNode(n ast.Node) (ast.Node, []*ast.ObjectKey, error) {
+	switch n.(type) {
+	case *ast.LiteralType, *ast.ListType, *ast.ObjectType, *ast.ObjectList:
+		return copyNode(n), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("cannot encode raw %T to HCL", n)
+	}
+}
+
+// copyNode deeply copies the tree rooted at n, so that positioning, redacting,
+// or modifying the encoded tree in OnBlock does not modify the original.
+func copyNode(n ast.Node) ast.Node {
+	switch n := n.(type) {
+	case *ast.LiteralType:
+		lit := newLiteral(n.Token)
+		lit.LeadComment, lit.LineComment = n.LeadComment, n.LineComment
+		return lit
+
+	case *ast.ListType:
+		list := &ast.ListType{List: make([]ast.Node, 0, len(n.List))}
+		for _, elem := range n.List {
+			list.Add(copyNode(elem))
+		}
+		return list
+
+	case *ast.ObjectType:
+		if n.List == nil {
+			return &ast.ObjectType{List: &ast.ObjectList{}}
+		}
+		return &ast.ObjectType{List: copyNode(n.List).(*ast.ObjectList)}
+
+	case *ast.ObjectList:
+		list := &ast.ObjectList{Items: make([]*ast.ObjectItem, 0, len(n.Items))}
+		for _, item := range n.Items {
+			keys := make([]*ast.ObjectKey, len(item.Keys))
+			for i, key := range item.Keys {
+				keys[i] = &ast.ObjectKey{Token: key.Token}
+			}
+			list.Add(&ast.ObjectItem{
+				Keys:        keys,
+				Val:         copyNode(item.Val),
+				LeadComment: item.LeadComment,
+				LineComment: item.LineComment,
+			})
+		}
+		return list
+
+	default:
+		return n
+	}
+}
+
+// encodeRat converts a big.Rat into an ast.LiteralType string, either as a
+// fraction or as a decimal if a precision is specified. An ast.ObjectKey is
+// never returned.
+func encodeRat(r *big.Rat, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	s := r.RatString()
+	if meta.hasPrecision {
+		s = r.FloatString(meta.precision)
+	}
+
+	tkn, _ := tokenize(reflect.ValueOf(s), false) // impossible to not be string
+	return newLiteral(tkn), nil, nil
+}
+
+// encodeBigFloat converts a big.Float into an ast.LiteralType number without
+// loss of precision. If a precision is specified, the number is rendered with
+// that many decimal places. An ast.ObjectKey is never returned.
+func encodeBigFloat(f *big.Float, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	if f.IsInf() {
+		return nil, nil, fmt.Errorf("cannot encode infinite %s to HCL", bigFloatType)
+	}
+
+	s := f.Text('g', -1)
+	if meta.hasPrecision {
+		s = f.Text('f', meta.precision)
+	}
+
+	return newLiteral(token.Token{Type: token.FLOAT, Text: s}), nil, nil
+}
+
+// encodeBytes converts a byte slice or array into an ast.LiteralType string,
+// either as raw UTF-8 text or base64 encoded if specified. An ast.ObjectKey is
+// never returned.
+func encodeBytes(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	b := make([]byte, in.Len())
+	for i := range b {
+		b[i] = byte(in.Index(i).Uint())
+	}
+
+	s := string(b)
+	if meta.base64 {
+		s = base64.StdEncoding.EncodeToString(b)
+	} else if !utf8.Valid(b) {
+		return nil, nil, fmt.Errorf("%s is not valid UTF-8, consider the %s tag", in.Type(), Base64Tag)
+	}
+
+	tkn, _ := tokenize(reflect.ValueOf(s), false) // impossible to not be string
+	return newLiteral(tkn), nil, nil
+}
+
+// encodeTextMarshaler converts a value implementing encoding.TextMarshaler into
+// an ast.LiteralType string. An ast.ObjectKey is never returned.
+func encodeTextMarshaler(m encoding.TextMarshaler) (ast.Node, []*ast.ObjectKey, error) {
+	text, err := m.MarshalText()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to marshal %T as text: %w", m, err)
+	}
+
+	tkn, _ := tokenize(reflect.ValueOf(string(text)), false) // impossible to not be string
+	return newLiteral(tkn), nil, nil
+}
+
+// encodeFormatDate converts t into an ast.LiteralType holding a call to the
+// formatdate function, which formats the RFC 3339 time with spec when
+// evaluated. An ast.ObjectKey is never returned.
+func encodeFormatDate(t time.Time, spec string) (ast.Node, []*ast.ObjectKey, error) {
+	return encodeExpr(fmt.Sprintf(`formatdate("%s", "%s")`, EscapeString(spec), t.Format(time.RFC3339)))
+}
+
+// isStringerType reports whether values of the struct type t are encoded as
+// the string returned by their String method, such as net.IPNet, url.URL, and
+// regexp.Regexp.
+func isStringerType(t reflect.Type) bool {
+	return t == ipNetType || t == urlType || t == regexpType
+}
+
+// encodeStringer converts s into an ast.LiteralType holding its String value.
+// An ast.ObjectKey is never returned.
+func encodeStringer(s fmt.Stringer) (ast.Node, []*ast.ObjectKey, error) {
+	tkn, _ := tokenize(reflect.ValueOf(s.String()), false) // impossible to not be string
+	return newLiteral(tkn), nil, nil
+}
+
+// isSQLNullType reports whether t is one of the nullable types from the
+// database/sql package, such as sql.NullString or sql.NullInt64.
+func isSQLNullType(t reflect.Type) bool {
+	return t.PkgPath() == "database/sql" && t.Implements(valuerType)
+}
+
+// encodeSQLNull converts a database/sql nullable value into the node for its
+// underlying value. Invalid values produce no node, and are treated as nil.
+func (e *Encoder) encodeSQLNull(v driver.Valuer, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	val, err := v.Value()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get value of %T: %w", v, err)
+	}
+	return e.encodeField(reflect.ValueOf(val), meta)
+}
+
+// encodeTimeWithZone converts a time.Time into an ast.ObjectType holding the
+// time as text and the name of its location. An ast.ObjectKey is never
+// returned.
+func encodeTimeWithZone(t time.Time) (ast.Node, []*ast.ObjectKey, error) {
+	val, _, err := encodeTextMarshaler(t)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return &ast.LiteralType{Token: tkn}, nil, nil
+	zone, _ := tokenize(reflect.ValueOf(t.Location().String()), false) // impossible to not be string
+	return &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+		{
+			Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "time"}}},
+			Val:  val,
+		},
+		{
+			Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zone"}}},
+			Val:  newLiteral(zone),
+		},
+	}}}, nil, nil
 }
 
 // encodeList converts a slice to an appropriate ast.Node type depending on its
 // element value type. An ast.ObjectKey is never returned.
-func encodeList(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+func (e *Encoder) encodeList(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
 	childType := in.Type().Elem()
 
 childLoop:
@@ -116,53 +746,299 @@ childLoop:
 		}
 	}
 
+	primitive := implementsTextMarshaler(childType) || isStringerType(childType) || isSQLNullType(childType)
 	switch childType.Kind() {
 	case reflect.Map, reflect.Struct, reflect.Interface:
-		return encodeBlockList(in)
 	default:
-		return encodePrimitiveList(in)
+		primitive = true
 	}
+
+	if meta.concat {
+		return e.encodeConcat(in, meta)
+	}
+
+	if meta.object {
+		return e.encodePairs(in, meta)
+	}
+
+	if meta.blocks && primitive {
+		return nil, nil, fmt.Errorf("blocks must be structs, %s given", childType)
+	}
+
+	if !primitive {
+		if meta.convert != "" {
+			return nil, nil, fmt.Errorf("%s requires a list of primitive values, %s given", meta.convert, in.Type())
+		}
+		return e.encodeBlockList(in, meta)
+	}
+
+	node, _, err := e.encodePrimitiveList(in, meta)
+	if err != nil || meta.convert == "" {
+		return node, nil, err
+	}
+
+	b := &bytes.Buffer{}
+	b.WriteString(meta.convert)
+	b.WriteByte('(')
+	printRaw(b, node)
+	b.WriteByte(')')
+	return encodeExpr(b.String())
 }
 
-// encodePrimitiveList converts a slice of primitive values to an ast.ListType. An
-// ast.ObjectKey is never returned.
-func encodePrimitiveList(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+// encodeConcat converts a slice of lists into an ast.LiteralType holding a call
+// to the concat function with each list as an argument. An element may also
+// be an expression, such as a variable holding a list. A ToSetTag or ToListTag
+// wraps the call as a whole. An ast.ObjectKey is never returned.
+func (e *Encoder) encodeConcat(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	inner := meta
+	inner.concat, inner.convert = false, ""
+
+	b := &bytes.Buffer{}
+	b.WriteString("concat(")
+	args := 0
+	for i := 0; i < in.Len(); i++ {
+		e.path = append(e.path, fmt.Sprintf("[%d]", i))
+		node, _, err := e.encodeField(in.Index(i), inner)
+		e.path = e.path[:len(e.path)-1]
+		if err != nil {
+			return nil, nil, e.wrapPath(err, fmt.Sprintf("[%d]", i))
+		}
+
+		if node == nil {
+			continue
+		}
+		if !isConcatArg(node) {
+			elem, _ := deref(in.Index(i))
+			err = fmt.Errorf("%s requires lists of primitive values, %s given", ConcatTag, elem.Type())
+			return nil, nil, e.wrapPath(err, fmt.Sprintf("[%d]", i))
+		}
+
+		if args > 0 {
+			b.WriteString(", ")
+		}
+		printRaw(b, node)
+		args++
+	}
+	b.WriteByte(')')
+
+	if meta.convert != "" {
+		return encodeExpr(fmt.Sprintf("%s(%s)", meta.convert, b.String()))
+	}
+	return encodeExpr(b.String())
+}
+
+// encodePairs converts a slice of key-value pair structs into an
+// ast.ObjectType, keeping the order of the slice. Each element must have a
+// string Key field and a Value field, to which the field formatting applies.
+// An ast.ObjectKey is never returned.
+func (e *Encoder) encodePairs(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	inner := meta
+	inner.object = false
+
+	l := in.Len()
+	list := &ast.ObjectList{Items: make([]*ast.ObjectItem, 0, l)}
+	seen := make(map[string]bool, l)
+
+	for i := 0; i < l; i++ {
+		pair, isNil := deref(in.Index(i))
+		if isNil {
+			continue
+		}
+		if pair.Kind() != reflect.Struct {
+			return nil, nil, e.wrapPath(fmt.Errorf("%s requires key-value pair structs, %s given", ObjectTag, pair.Type()), fmt.Sprintf("[%d]", i))
+		}
+
+		key, val := pair.FieldByName("Key"), pair.FieldByName("Value")
+		if !key.IsValid() || key.Kind() != reflect.String || !val.IsValid() {
+			return nil, nil, e.wrapPath(fmt.Errorf("%s requires a string Key field and a Value field, %s given", ObjectTag, pair.Type()), fmt.Sprintf("[%d]", i))
+		}
+
+		name := key.String()
+		if seen[name] {
+			return nil, nil, e.wrapPath(fmt.Errorf("duplicate key %q", name), fmt.Sprintf("[%d]", i))
+		}
+		seen[name] = true
+
+		e.path = append(e.path, name)
+		node, childKeys, err := e.encodeField(val, inner)
+		e.path = e.path[:len(e.path)-1]
+		if err != nil {
+			return nil, nil, e.wrapPath(err, name)
+		}
+		if node == nil {
+			continue
+		}
+
+		tkn, _ := tokenize(key, isIdent(name)) // impossible to not be string
+		item := &ast.ObjectItem{Keys: append([]*ast.ObjectKey{{Token: tkn}}, childKeys...), Val: node}
+		list.Add(item)
+	}
+
+	return &ast.ObjectType{List: list}, nil, nil
+}
+
+// isConcatArg returns true if node is a list or an expression that may be
+// passed to the concat function.
+func isConcatArg(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.ListType:
+		return true
+	case *ast.LiteralType:
+		return node.Token.Type == token.IDENT
+	default:
+		return false
+	}
+}
+
+// encodeChan receives values from a channel until it is closed, encoding the
+// values as if they were a slice. Like a nil slice, a nil channel produces no
+// node. An ast.ObjectKey is never returned.
+func (e *Encoder) encodeChan(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	if in.Type().ChanDir()&reflect.RecvDir == 0 {
+		return nil, nil, fmt.Errorf("cannot drain send-only channel %s", in.Type())
+	}
+
+	if in.IsNil() {
+		return nil, nil, nil
+	}
+
+	list := reflect.MakeSlice(reflect.SliceOf(in.Type().Elem()), 0, in.Len())
+
+	for {
+		if list.Len() == MaxDrainCount {
+			// only a closed channel can be drained at the limit without
+			// blocking or consuming another value
+			if in.Len() == 0 {
+				if v, ok := in.TryRecv(); v.IsValid() && !ok {
+					break
+				}
+			}
+			return nil, nil, fmt.Errorf("channel %s exceeded %d values, is it closed?", in.Type(), MaxDrainCount)
+		}
+		v, ok := in.Recv()
+		if !ok {
+			break
+		}
+		list = reflect.Append(list, v)
+	}
+
+	return e.encodeList(list, meta)
+}
+
+// encodePrimitiveList converts a slice of primitive values to an ast.ListType.
+// The field formatting applies to each element. An ast.ObjectKey is never
+// returned.
+func (e *Encoder) encodePrimitiveList(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
 	l := in.Len()
 	n := &ast.ListType{List: make([]ast.Node, 0, l)}
 
+	more := 0
 	for i := 0; i < l; i++ {
-		child, _, err := encode(in.Index(i))
+		if e.TruncateLen > 0 && len(n.List) == e.TruncateLen {
+			more = l - i
+			break
+		}
+
+		e.path = append(e.path, fmt.Sprintf("[%d]", i))
+		child, _, err := e.encodeField(in.Index(i), meta)
+		e.path = e.path[:len(e.path)-1]
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, e.wrapPath(err, fmt.Sprintf("[%d]", i))
+		}
+		if child == nil {
+			if !e.EmitNull {
+				continue
+			}
+			child = newLiteral(token.Token{Type: token.IDENT, Text: "null"})
 		}
-		if child != nil {
-			n.Add(child)
+		if lit, ok := child.(*ast.LiteralType); ok && e.ListIndexComments {
+			lit.LineComment = &ast.CommentGroup{List: []*ast.Comment{{Text: fmt.Sprintf("%s %d", e.commentStyle(), i)}}}
+		}
+		n.Add(child)
+	}
+
+	if more > 0 {
+		if lit, ok := n.List[len(n.List)-1].(*ast.LiteralType); ok {
+			lit.LineComment = e.appendTruncation(lit.LineComment, more)
+		} else {
+			// the printer only emits the comments of literal list elements, so
+			// the note is attached to the enclosing item once encoded
+			if e.truncated == nil {
+				e.truncated = make(map[*ast.ListType]int)
+			}
+			e.truncated[n] = more
 		}
 	}
 
 	return n, nil, nil
 }
 
-// encodeBlockList converts a slice of non-primitive types to an ast.ObjectList. An
-// ast.ObjectKey is never returned.
-func encodeBlockList(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+// encodeBlockList converts a slice of non-primitive types to an ast.ObjectList.
+// Unless the field is tagged as blocks, the slice falls back to an ast.ListType
+// if any element lacks a key. Otherwise, each element must be a block, and
+// unkeyed elements are repeated under the field name. StructSlicesAsBlocks
+// treats every slice held by a field or map entry as if it were tagged, except
+// that it falls back to an ast.ListType instead of failing on a non-block
+// element. An ast.ObjectKey is never returned.
+func (e *Encoder) encodeBlockList(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
 	l := in.Len()
 	n := &ast.ObjectList{Items: make([]*ast.ObjectItem, 0, l)}
 
+	// only the slices held by a field or map entry have a key to repeat, and
+	// dynamic fields wrap their content in blocks of their own
+	blocks := meta.blocks
+	if e.StructSlicesAsBlocks && !meta.dynamic && len(e.path) > 0 && !strings.HasPrefix(e.path[len(e.path)-1], "[") {
+		blocks = true
+	}
+
+	var seen map[string]struct{}
+	if e.DedupeBlocks {
+		seen = make(map[string]struct{}, l)
+	}
+
+	// the elements are encoded again if the slice falls back to a list, so
+	// discard the errors collected so far to avoid reporting them twice
+	errs := len(e.errs)
+	fallback := func() (ast.Node, []*ast.ObjectKey, error) {
+		e.errs = e.errs[:errs]
+		return e.encodePrimitiveList(in, meta)
+	}
+
 	for i := 0; i < l; i++ {
-		child, childKey, err := encode(in.Index(i))
+		e.path = append(e.path, fmt.Sprintf("[%d]", i))
+		child, childKey, err := e.encode(in.Index(i))
+		e.path = e.path[:len(e.path)-1]
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, e.wrapPath(err, fmt.Sprintf("[%d]", i))
 		}
 		if child == nil {
 			continue
 		}
-		if childKey == nil {
-			return encodePrimitiveList(in)
+		if blocks {
+			if _, ok := child.(*ast.ObjectType); !ok {
+				if !meta.blocks {
+					return fallback()
+				}
+				elem, _ := deref(in.Index(i))
+				err = fmt.Errorf("blocks must be structs, %s given", elem.Type())
+				return nil, nil, e.wrapPath(err, fmt.Sprintf("[%d]", i))
+			}
+		} else if childKey == nil {
+			return fallback()
 		}
 
 		item := &ast.ObjectItem{Val: child}
 		item.Keys = childKey
+
+		if seen != nil {
+			b := &bytes.Buffer{}
+			printRaw(b, item)
+			if _, ok := seen[b.String()]; ok {
+				continue
+			}
+			seen[b.String()] = struct{}{}
+		}
+
 		n.Add(item)
 	}
 
@@ -170,19 +1046,50 @@ func encodeBlockList(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
 }
 
 // encodeMap converts a map type into an ast.ObjectType. Maps must have string
-// key values to be encoded. An ast.ObjectKey is never returned.
-func encodeMap(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
-	if keyType := in.Type().Key().Kind(); keyType != reflect.String {
+// key values to be encoded, though interface keys holding strings are
+// permitted. The field formatting applies to each value. An ast.ObjectKey is
+// never returned.
+func (e *Encoder) encodeMap(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	order, ordered := e.MapKeyOrder[in.Type()]
+
+	if e.JSONMapKeys && in.Type().Key().Kind() == reflect.Struct {
+		var err error
+		if in, err = jsonKeyedMap(in); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keyType := in.Type().Key().Kind()
+	if keyType != reflect.String && keyType != reflect.Interface {
 		return nil, nil, fmt.Errorf("map keys must be strings, %s given", keyType)
 	}
 
-	l := make(objectItems, 0, in.Len())
-	for _, key := range in.MapKeys() {
-		tkn, _ := tokenize(key, true) // error impossible since we've already checked key kind
+	keys := in.MapKeys()
+	if keyType == reflect.Interface {
+		for _, key := range keys {
+			if kind := key.Elem().Kind(); kind != reflect.String {
+				return nil, nil, fmt.Errorf("map keys must be strings, %s given", kind)
+			}
+		}
+	}
+	switch {
+	case ordered:
+		keys = orderMapKeys(keys, order)
+	case e.MapKeySort != nil:
+		keys = e.sortMapKeys(keys)
+	}
 
-		val, childKey, err := encode(in.MapIndex(key))
+	l := make(objectItems, 0, in.Len())
+	for _, key := range keys {
+		name := mapKeyName(key)
+		// keys are only bare when they are valid identifiers
+		tkn, _ := tokenize(reflect.ValueOf(name), isIdent(name)) // error impossible since we've already checked key kind
+
+		e.path = append(e.path, name)
+		val, childKey, err := e.encodeField(in.MapIndex(key), meta)
+		e.path = e.path[:len(e.path)-1]
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, e.wrapPath(err, name)
 		}
 		if val == nil {
 			continue
@@ -211,25 +1118,231 @@ func encodeMap(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
 			}
 			l = append(l, item)
 
-		}
+		}
+
+	}
+
+	if !ordered && e.MapKeySort == nil {
+		sort.Sort(l)
+	}
+	if e.TruncateLen > 0 && len(l) > e.TruncateLen {
+		more := len(l) - e.TruncateLen
+		l = l[:e.TruncateLen]
+		l[len(l)-1].LineComment = e.appendTruncation(l[len(l)-1].LineComment, more)
+	}
+	return &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem(l)}}, nil, nil
+}
+
+// appendTruncation appends a note of the number of elements of a list or map
+// dropped by TruncateLen to the line comment c, which may be nil.
+func (e *Encoder) appendTruncation(c *ast.CommentGroup, more int) *ast.CommentGroup {
+	note := fmt.Sprintf("... and %d more", more)
+	if c == nil || len(c.List) == 0 {
+		return &ast.CommentGroup{List: []*ast.Comment{{Text: fmt.Sprintf("%s %s", e.commentStyle(), note)}}}
+	}
+	// the printer joins line comments without a space, so extend the last
+	last := c.List[len(c.List)-1]
+	last.Text += " " + note
+	return c
+}
+
+// noteTruncations attaches the notes of the lists in the tree rooted at n that
+// were truncated after a non-literal element to the line comment of their
+// nearest enclosing item.
+func (e *Encoder) noteTruncations(n ast.Node, item *ast.ObjectItem) {
+	switch n := n.(type) {
+	case *ast.ObjectList:
+		for _, child := range n.Items {
+			e.noteTruncations(child.Val, child)
+		}
+	case *ast.ObjectType:
+		e.noteTruncations(n.List, item)
+	case *ast.ListType:
+		for _, child := range n.List {
+			e.noteTruncations(child, item)
+		}
+		if more, ok := e.truncated[n]; ok && item != nil {
+			item.LineComment = e.appendTruncation(item.LineComment, more)
+		}
+	}
+}
+
+// encodeMapBlocks converts a map of structs into an ast.ObjectList of blocks,
+// each labeled by its map key followed by any key fields of the struct. The
+// blocks are ordered like the keys of encodeMap. An ast.ObjectKey is never
+// returned.
+func (e *Encoder) encodeMapBlocks(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	node, _, err := e.encodeMap(in, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := node.(*ast.ObjectType).List.Items
+	for _, item := range items {
+		if _, ok := item.Val.(*ast.ObjectType); !ok {
+			return nil, nil, fmt.Errorf("blocks must be structs, %s given", in.Type().Elem())
+		}
+		label := item.Keys[0].Token
+		if label.Type != token.STRING {
+			label, _ = tokenize(reflect.ValueOf(label.Text), false) // impossible to not be string
+		}
+		item.Keys[0] = &ast.ObjectKey{Token: label}
+	}
+
+	return &ast.ObjectList{Items: items}, nil, nil
+}
+
+// encodeSet converts a map[T]struct{} into an ast.ListType of its keys, sorted
+// in ascending order. Keys must be strings or numbers. The field formatting
+// applies to each key. An ast.ObjectKey is never returned.
+func (e *Encoder) encodeSet(in reflect.Value, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	typ := in.Type()
+	if elem := typ.Elem(); elem.Kind() != reflect.Struct || elem.NumField() != 0 {
+		return nil, nil, fmt.Errorf("set values must be struct{}, %s given", elem)
+	}
+
+	keys := in.MapKeys()
+	var less func(a, b reflect.Value) bool
+	switch typ.Key().Kind() {
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float64:
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	default:
+		return nil, nil, fmt.Errorf("set keys must be strings or numbers, %s given", typ.Key().Kind())
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+
+	list := reflect.MakeSlice(reflect.SliceOf(typ.Key()), len(keys), len(keys))
+	for i, key := range keys {
+		list.Index(i).Set(key)
+	}
+	return e.encodeList(list, meta)
+}
+
+// jsonKeyedMap copies a map with struct keys into a map keyed by the JSON
+// encoding of each key.
+func jsonKeyedMap(in reflect.Value) (reflect.Value, error) {
+	out := reflect.MakeMapWithSize(reflect.MapOf(reflect.TypeOf(""), in.Type().Elem()), in.Len())
+	iter := in.MapRange()
+	for iter.Next() {
+		b, err := json.Marshal(iter.Key().Interface())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("unable to encode map key %s as JSON: %w", in.Type().Key(), err)
+		}
+		key := reflect.ValueOf(string(b))
+		if out.MapIndex(key).IsValid() {
+			return reflect.Value{}, fmt.Errorf("duplicate JSON map key %s", b)
+		}
+		out.SetMapIndex(key, iter.Value())
+	}
+	return out, nil
+}
+
+// mapKeyName returns the string held by the map key, unwrapping an interface.
+func mapKeyName(key reflect.Value) string {
+	if key.Kind() == reflect.Interface {
+		key = key.Elem()
+	}
+	return key.String()
+}
+
+// orderMapKeys orders the string map keys by their position in order. Keys
+// not present in order follow in ascending order.
+func orderMapKeys(keys []reflect.Value, order []string) []reflect.Value {
+	rank := make(map[string]int, len(order))
+	for i, key := range order {
+		if _, ok := rank[key]; !ok {
+			rank[key] = i
+		}
+	}
+
+	pos := func(key string) int {
+		if i, ok := rank[key]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := mapKeyName(keys[i]), mapKeyName(keys[j])
+		if pa, pb := pos(a), pos(b); pa != pb {
+			return pa < pb
+		}
+		return a < b
+	})
+	return keys
+}
+
+// rootBlock wraps the encoded root value node in a block of type typ, labeled
+// by keys. The root value must be an object.
+func rootBlock(typ string, node ast.Node, keys []*ast.ObjectKey) (ast.Node, error) {
+	obj, ok := node.(*ast.ObjectType)
+	if !ok {
+		return nil, fmt.Errorf("root value must be a struct or map to be encoded as a %s block", typ)
+	}
+
+	tkn, _ := tokenize(reflect.ValueOf(typ), isIdent(typ)) // impossible to not be string
+	return &ast.ObjectList{Items: []*ast.ObjectItem{{
+		Keys: append([]*ast.ObjectKey{{Token: tkn}}, keys...),
+		Val:  obj,
+	}}}, nil
+}
 
+// sortMapKeys orders the string map keys using the Encoder's MapKeySort.
+func (e *Encoder) sortMapKeys(keys []reflect.Value) []reflect.Value {
+	names := make([]string, len(keys))
+	byName := make(map[string]reflect.Value, len(keys))
+	for i, key := range keys {
+		names[i] = mapKeyName(key)
+		byName[names[i]] = key
 	}
 
-	sort.Sort(l)
-	return &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem(l)}}, nil, nil
+	e.MapKeySort(names)
+
+	sorted := make([]reflect.Value, 0, len(names))
+	for _, name := range names {
+		if key, ok := byName[name]; ok {
+			sorted = append(sorted, key)
+		}
+	}
+	return sorted
 }
 
 // encodeStruct converts a struct type into an ast.ObjectType. An ast.ObjectKey
 // may be returned if a KeyTag is present that should be used by a parent
 // ast.ObjectItem if this node is nested.
-func encodeStruct(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+func (e *Encoder) encodeStruct(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+	// only the fields of the root struct are filtered by file
+	file := e.file
+	e.file = nil
+	defer func() { e.file = file }()
+
+	metas, err := e.fieldMetas(in.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+
 	l := in.NumField()
 	list := &ast.ObjectList{Items: make([]*ast.ObjectItem, 0, l)}
 	keys := make([]*ast.ObjectKey, 0)
-
-	for i := 0; i < l; i++ {
-		field := in.Type().Field(i)
-		meta := extractFieldMeta(field)
+	nested := make(map[*ast.ObjectItem]bool)
+	var inherit []*ast.ObjectItem
+
+	var (
+		sections []sectionStart
+		section  string
+	)
+	for _, i := range sectionOrder(metas) {
+		meta := metas[i]
+		if meta.section != section {
+			section = meta.section
+			sections = append(sections, sectionStart{name: section, index: len(list.Items)})
+		}
 
 		// these tags are used for debugging the decoder
 		// they should not be output
@@ -237,32 +1350,79 @@ func encodeStruct(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
 			continue
 		}
 
-		tkn, _ := tokenize(reflect.ValueOf(meta.name), true) // impossible to not be string
+		if meta.sensitive && e.OmitSensitive {
+			continue
+		}
+
+		if file != nil && meta.fileName() != *file {
+			continue
+		}
 
 		// if the OmitEmptyTag is provided, check if the value is its zero value.
 		rawVal := in.Field(i)
-		if meta.omitEmpty {
-			zeroVal := reflect.Zero(rawVal.Type()).Interface()
-			if reflect.DeepEqual(rawVal.Interface(), zeroVal) {
-				continue
+		if meta.omitEmpty && isEmpty(rawVal) {
+			// a nested block is omitted entirely rather than emitted without
+			// a label
+			if meta.key && len(e.path) > 0 {
+				return nil, nil, nil
 			}
+			continue
 		}
 
-		val, childKeys, err := encode(rawVal)
+		var (
+			val       ast.Node
+			childKeys []*ast.ObjectKey
+		)
+		if meta.presence {
+			val, err = encodePresence(rawVal)
+		} else {
+			e.path = append(e.path, meta.name)
+			val, childKeys, err = e.encodeField(rawVal, meta)
+			e.path = e.path[:len(e.path)-1]
+		}
 		if err != nil {
+			err = e.wrapPath(err, meta.name)
+			if e.MultiError {
+				e.errs = append(e.errs, err)
+				continue
+			}
 			return nil, nil, err
 		}
+		// nil values are skipped, including nil embedded pointers that would
+		// otherwise be squashed, unless they should be kept as null
+		if val == nil && meta.keepNil && !meta.anonymous && !meta.presence {
+			val = newLiteral(token.Token{Type: token.IDENT, Text: "null"})
+		}
 		if val == nil {
 			continue
 		}
 
+		// this field is wrapped in dynamic block scaffolding
+		if meta.dynamic {
+			if len(childKeys) > 0 {
+				return nil, nil, e.wrapPath(errors.New("dynamic block content cannot have key fields"), meta.name)
+			}
+			blocks, err := dynamicBlocks(meta, val)
+			if err != nil {
+				return nil, nil, e.wrapPath(err, meta.name)
+			}
+			if meta.comment != "" && len(blocks) > 0 {
+				blocks[0].LeadComment = commentGroup(e.commentStyle(), meta.comment)
+			}
+			list.Items = append(list.Items, blocks...)
+			continue
+		}
+
 		// this field is a key and should be bubbled up to the parent node
 		if meta.key {
 			if lit, ok := val.(*ast.LiteralType); ok && lit.Token.Type == token.STRING {
+				if e.RequireLabels && lit.Token.Text == `""` {
+					return nil, nil, e.wrapPath(errors.New("struct key fields cannot be empty"), meta.name)
+				}
 				keys = append(keys, &ast.ObjectKey{Token: lit.Token})
 				continue
 			}
-			return nil, nil, errors.New("struct key fields must be string literals")
+			return nil, nil, e.wrapPath(errors.New("struct key fields must be string literals"), meta.name)
 		}
 
 		// this field is anonymous and should be squashed into the parent struct's fields
@@ -277,37 +1437,231 @@ func encodeStruct(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
 			}
 		}
 
+		// dotted names are emitted within intermediate blocks, shared by
+		// fields with the same prefix
+		dst, name := list, meta.name
+		if path := dottedPath(meta.name); path != nil {
+			name = path[len(path)-1]
+			if dst, err = nestedList(list, path[:len(path)-1], nested); err != nil {
+				return nil, nil, e.wrapPath(err, meta.name)
+			}
+		}
+
+		tkn, _ := tokenize(reflect.ValueOf(name), isIdent(name)) // impossible to not be string
+		for _, item := range dst.Items {
+			if nested[item] && item.Keys[0].Token.Text == tkn.Text {
+				return nil, nil, e.wrapPath(fmt.Errorf("key %q is also the prefix of a dotted field name", name), meta.name)
+			}
+		}
+
+		// the split entry of a map is emitted after the map itself
+		var split []*ast.ObjectItem
+		if obj, ok := val.(*ast.ObjectType); ok && meta.split != "" {
+			split = splitItems(obj.List, meta.split)
+		}
+
 		itemKey := &ast.ObjectKey{Token: tkn}
+		first := len(dst.Items)
 
 		// if the item is an object list, we need to flatten out the items
 		if objectList, ok := val.(*ast.ObjectList); ok {
 			for _, obj := range objectList.Items {
 				objectKeys := append([]*ast.ObjectKey{itemKey}, obj.Keys...)
-				list.Add(&ast.ObjectItem{
+				dst.Add(&ast.ObjectItem{
 					Keys: objectKeys,
 					Val:  obj.Val,
 				})
 			}
-			continue
+		} else {
+			item := &ast.ObjectItem{
+				Keys: []*ast.ObjectKey{itemKey},
+				Val:  val,
+			}
+			if childKeys != nil {
+				item.Keys = append(item.Keys, childKeys...)
+			}
+			if _, ok := val.(*ast.ObjectType); !ok && meta.inlineComment != "" {
+				item.LineComment = commentGroup(e.commentStyle(), strings.Replace(meta.inlineComment, "\n", " ", -1))
+			}
+			dst.Add(item)
 		}
 
-		item := &ast.ObjectItem{
-			Keys: []*ast.ObjectKey{itemKey},
-			Val:  val,
+		// the comment leads the first item emitted for this field
+		if meta.comment != "" && len(dst.Items) > first {
+			dst.Items[first].LeadComment = commentGroup(e.commentStyle(), meta.comment)
+		}
+
+		// the labels of this struct are not known until all fields are seen
+		if meta.inheritLabels {
+			for _, item := range dst.Items[first:] {
+				if _, ok := item.Val.(*ast.ObjectType); !ok {
+					return nil, nil, e.wrapPath(fmt.Errorf("%s requires a block", InheritLabelsTag), meta.name)
+				}
+				inherit = append(inherit, item)
+			}
 		}
-		if childKeys != nil {
-			item.Keys = append(item.Keys, childKeys...)
+
+		dst.Items = append(dst.Items, split...)
+	}
+	if e.SortFields {
+		start := 0
+		for _, section := range sections {
+			sortItems(list.Items[start:section.index])
+			start = section.index
+		}
+		sortItems(list.Items[start:])
+		for item := range nested {
+			sortItems(item.Val.(*ast.ObjectType).List.Items)
+		}
+	}
+
+	for j, section := range sections {
+		end := len(list.Items)
+		if j < len(sections)-1 {
+			end = sections[j+1].index
+		}
+		if section.index == end {
+			continue
+		}
+		item := list.Items[section.index]
+		header := commentGroup(e.commentStyle(), section.name)
+		if item.LeadComment != nil {
+			header.List = append(header.List, item.LeadComment.List...)
+		}
+		item.LeadComment = header
+	}
+
+	if name, ok := duplicateName(list); ok {
+		return nil, nil, fmt.Errorf("duplicate attribute %q", name)
+	}
+	for item := range nested {
+		if name, ok := duplicateName(item.Val.(*ast.ObjectType).List); ok {
+			return nil, nil, fmt.Errorf("duplicate attribute %q", name)
 		}
-		list.Add(item)
 	}
+
 	if len(keys) == 0 {
 		return &ast.ObjectType{List: list}, nil, nil
 	}
+
+	for _, item := range inherit {
+		itemKeys := make([]*ast.ObjectKey, 0, len(item.Keys)+len(keys))
+		itemKeys = append(itemKeys, item.Keys[0])
+		for _, key := range keys {
+			itemKeys = append(itemKeys, &ast.ObjectKey{Token: key.Token})
+		}
+		item.Keys = append(itemKeys, item.Keys[1:]...)
+	}
+
 	return &ast.ObjectType{List: list}, keys, nil
 }
 
+// sortItems sorts items by their first key, keeping the order of items with
+// the same key, such as repeated blocks.
+func sortItems(items []*ast.ObjectItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return blockPath("", items[i].Keys[:1]) < blockPath("", items[j].Keys[:1])
+	})
+}
+
+// sectionStart is the index of the first item of a section within a list.
+type sectionStart struct {
+	name  string
+	index int
+}
+
+// sectionOrder returns the indices of metas with the fields without a section
+// first, followed by the fields of each section in the order of their first
+// field. Fields otherwise keep their relative order.
+func sectionOrder(metas []fieldMeta) []int {
+	order := make([]int, 0, len(metas))
+	var names []string
+	bySection := make(map[string][]int)
+	for i, meta := range metas {
+		if meta.section == "" {
+			order = append(order, i)
+			continue
+		}
+		if _, ok := bySection[meta.section]; !ok {
+			names = append(names, meta.section)
+		}
+		bySection[meta.section] = append(bySection[meta.section], i)
+	}
+	for _, name := range names {
+		order = append(order, bySection[name]...)
+	}
+	return order
+}
+
+// duplicateName returns the first name in list given to more than one
+// attribute, or to both an attribute and a block, such as a field of a
+// squashed struct that shadows another field. Blocks may repeat.
+func duplicateName(list *ast.ObjectList) (string, bool) {
+	attrs := make(map[string]bool, len(list.Items))
+	for _, item := range list.Items {
+		if len(item.Keys) == 0 {
+			continue
+		}
+		name := blockPath("", item.Keys[:1])
+		_, block := item.Val.(*ast.ObjectType)
+		if isAttr, seen := attrs[name]; seen && (isAttr || !block) {
+			return name, true
+		}
+		attrs[name] = !block
+	}
+	return "", false
+}
+
+// dottedPath splits a field name of the form "a.b.c" into its segments. Nil is
+// returned if the name has no dots or an empty segment.
+func dottedPath(name string) []string {
+	path := strings.Split(name, ".")
+	if len(path) < 2 {
+		return nil
+	}
+	for _, seg := range path {
+		if seg == "" {
+			return nil
+		}
+	}
+	return path
+}
+
+// nestedList returns the list of the intermediate block at path within list,
+// creating any missing blocks. Created blocks are recorded in nested, and an
+// error is returned if a segment of path names an item that is not one.
+func nestedList(list *ast.ObjectList, path []string, nested map[*ast.ObjectItem]bool) (*ast.ObjectList, error) {
+	for _, seg := range path {
+		tkn, _ := tokenize(reflect.ValueOf(seg), isIdent(seg)) // impossible to not be string
+
+		var next *ast.ObjectItem
+		for _, item := range list.Items {
+			if len(item.Keys) > 0 && item.Keys[0].Token.Text == tkn.Text {
+				next = item
+				break
+			}
+		}
+
+		switch {
+		case next == nil:
+			next = &ast.ObjectItem{
+				Keys: []*ast.ObjectKey{{Token: tkn}},
+				Val:  &ast.ObjectType{List: &ast.ObjectList{}},
+			}
+			nested[next] = true
+			list.Add(next)
+		case !nested[next]:
+			return nil, fmt.Errorf("prefix %q is also the key of another field", seg)
+		}
+
+		list = next.Val.(*ast.ObjectType).List
+	}
+	return list, nil
+}
+
 // tokenize converts a primitive type into an token.Token. IDENT tokens (unquoted strings)
-// can be optionally triggered for any string types.
+// can be optionally triggered for any string types, except for reserved keywords which
+// are always quoted.
 func tokenize(in reflect.Value, ident bool) (t token.Token, err error) {
 	switch in.Kind() {
 	case reflect.Bool:
@@ -329,13 +1683,16 @@ func tokenize(in reflect.Value, ident bool) (t token.Token, err error) {
 		}, nil
 
 	case reflect.Float64:
+		if f := in.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return t, fmt.Errorf("cannot encode non-finite float %v to HCL", f)
+		}
 		return token.Token{
 			Type: token.FLOAT,
-			Text: strconv.FormatFloat(in.Float(), 'g', -1, 64),
+			Text: formatFloat(in.Float()),
 		}, nil
 
 	case reflect.String:
-		if ident {
+		if ident && !isKeyword(in.String()) {
 			return token.Token{
 				Type: token.IDENT,
 				Text: in.String(),
@@ -343,15 +1700,348 @@ func tokenize(in reflect.Value, ident bool) (t token.Token, err error) {
 		}
 		return token.Token{
 			Type: token.STRING,
-			Text: fmt.Sprintf(`"%s"`, in.String()),
+			Text: fmt.Sprintf(`"%s"`, EscapeString(in.String())),
 		}, nil
 	}
 
 	return t, fmt.Errorf("cannot encode primitive kind %s to token", in.Kind())
 }
 
-// extractFieldMeta pulls information about struct fields and the optional HCL tags
-func extractFieldMeta(f reflect.StructField) (meta fieldMeta) {
+// maxExactFloat is the largest magnitude at which a float64 can precisely
+// represent every whole number
+const maxExactFloat = 1 << 53
+
+// formatFloat renders f in its shortest representation. Whole numbers that
+// can be represented exactly are never emitted in scientific notation.
+func formatFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) <= maxExactFloat {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// heredoc converts s into a heredoc token, choosing an anchor that does not
+// appear as a line of s.
+func heredoc(s string) token.Token {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+
+	lines := make(map[string]struct{})
+	for _, line := range strings.Split(s, "\n") {
+		lines[strings.TrimSpace(line)] = struct{}{}
+	}
+
+	anchor := "EOF"
+	for i := 1; ; i++ {
+		if _, ok := lines[anchor]; !ok {
+			break
+		}
+		anchor = fmt.Sprintf("EOF%d", i)
+	}
+
+	return token.Token{
+		Type: token.HEREDOC,
+		Text: fmt.Sprintf("<<%s\n%s%s\n", anchor, s, anchor),
+	}
+}
+
+// escapeLiteral behaves like escape, but escapes the dollar sign of each
+// interpolation sequence (eg, "${x}" becomes "$${x}") so it is not evaluated.
+func escapeLiteral(s string, escape func(string) string) string {
+	parts := strings.Split(s, "${")
+	for i, part := range parts {
+		parts[i] = escape(part)
+	}
+	return strings.Join(parts, "$${")
+}
+
+// EscapeString escapes s for use as the contents of a quoted HCL string.
+// Interpolation sequences (eg, "${var.foo}") are preserved verbatim so they
+// remain valid. The dollar sign of an unterminated sequence is escaped so the
+// string can still be parsed.
+func EscapeString(s string) string {
+	b := &strings.Builder{}
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch r {
+		case '$':
+			if !strings.HasPrefix(s[i+size:], "{") {
+				b.WriteRune(r)
+			} else if n := interpolationLen(s[i+size:]); n > 0 {
+				b.WriteString(s[i : i+size+n])
+				size += n
+			} else {
+				b.WriteString(`\u0024`)
+			}
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if unicode.IsControl(r) {
+				fmt.Fprintf(b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+
+		i += size
+	}
+
+	return b.String()
+}
+
+// interpolationLen returns the length in bytes of the interpolation body at the
+// start of s, from its opening brace through the matching closing brace. Zero
+// is returned if the braces are unbalanced.
+func interpolationLen(s string) int {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// dynamicBlocks wraps the object in val, either an ast.ObjectType or an
+// ast.ListType holding at most one, in a Terraform dynamic block labeled with
+// the field name:
+//
+//	dynamic "name" {
+//	  for_each = expr
+//	  content {
+//	    ...
+//	  }
+//	}
+//
+// Terraform repeats the content for each element of for_each, so a slice may
+// only hold the single content template. An empty slice produces no block.
+func dynamicBlocks(meta fieldMeta, val ast.Node) ([]*ast.ObjectItem, error) {
+	var content *ast.ObjectType
+	switch val := val.(type) {
+	case *ast.ObjectType:
+		content = val
+	case *ast.ListType:
+		if len(val.List) > 1 {
+			return nil, fmt.Errorf("dynamic blocks take a single content template, %d given", len(val.List))
+		}
+		if len(val.List) == 0 {
+			return nil, nil
+		}
+		obj, ok := val.List[0].(*ast.ObjectType)
+		if !ok {
+			return nil, errors.New("dynamic blocks must be structs or slices of structs")
+		}
+		content = obj
+	case *ast.ObjectList:
+		return nil, errors.New("dynamic block content cannot have key fields")
+	default:
+		return nil, errors.New("dynamic blocks must be structs or slices of structs")
+	}
+
+	label, _ := tokenize(reflect.ValueOf(meta.name), false)
+	return []*ast.ObjectItem{{
+		Keys: []*ast.ObjectKey{
+			{Token: token.Token{Type: token.IDENT, Text: "dynamic"}},
+			{Token: label},
+		},
+		Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+			{
+				Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "for_each"}}},
+				Val:  newLiteral(token.Token{Type: token.IDENT, Text: meta.forEach}),
+			},
+			{
+				Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "content"}}},
+				Val:  content,
+			},
+		}}},
+	}}, nil
+}
+
+// encodePresence converts a bool into an empty ast.ObjectType if true, or nil
+// if false so that it is omitted.
+func encodePresence(in reflect.Value) (ast.Node, error) {
+	in, isNil := deref(in)
+	if isNil {
+		return nil, nil
+	}
+	if in.Kind() != reflect.Bool {
+		return nil, fmt.Errorf("presence fields must be bools, %s given", in.Kind())
+	}
+	if !in.Bool() {
+		return nil, nil
+	}
+	return &ast.ObjectType{List: &ast.ObjectList{}}, nil
+}
+
+// splitItems removes the items whose first key is key from list, returning
+// them.
+func splitItems(list *ast.ObjectList, key string) []*ast.ObjectItem {
+	var split []*ast.ObjectItem
+	items := list.Items[:0]
+	for _, item := range list.Items {
+		if k, ok := item.Keys[0].Token.Value().(string); ok && k == key {
+			split = append(split, item)
+			continue
+		}
+		items = append(items, item)
+	}
+	list.Items = items
+	return split
+}
+
+// splitTag splits an hcle tag value on its commas. Commas escaped with a
+// backslash are not treated as separators and are unescaped.
+func splitTag(tag string) []string {
+	var (
+		tags []string
+		cur  strings.Builder
+	)
+
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			cur.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			tags = append(tags, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(tag[i])
+		}
+	}
+
+	return append(tags, cur.String())
+}
+
+// commentGroup converts text into a group of single-line comments with the
+// given style, one for each line of text.
+func commentGroup(style CommentStyle, text string) *ast.CommentGroup {
+	lines := strings.Split(text, "\n")
+	group := &ast.CommentGroup{List: make([]*ast.Comment, 0, len(lines))}
+	for _, line := range lines {
+		c := string(style)
+		if line = strings.TrimRight(line, " \t\r"); line != "" {
+			c += " " + line
+		}
+		group.List = append(group.List, &ast.Comment{Text: c})
+	}
+	return group
+}
+
+// splitDirective separates an hcle tag directive of the form "name:arg" into
+// its name and argument. The argument is empty if not present.
+func splitDirective(tag string) (name, arg string) {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// splitOption separates an hcl tag option into its name and the value
+// following an equals sign, if any.
+func splitOption(tag string) (name, value string) {
+	if i := strings.IndexByte(tag, '='); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// keywords are identifiers reserved by HCL parsers. Object keys matching one of
+// these are quoted so they cannot be confused with literals or expressions.
+var keywords = map[string]struct{}{
+	"true":  {},
+	"false": {},
+	"null":  {},
+	"for":   {},
+	"in":    {},
+	"if":    {},
+}
+
+// isKeyword returns true if s is a reserved HCL keyword
+func isKeyword(s string) bool {
+	_, ok := keywords[s]
+	return ok
+}
+
+// isIdent returns true if s can be emitted as a bare identifier. HCL
+// identifiers begin with a letter or underscore, followed by any number of
+// letters, digits, underscores, dashes, or dots. Keywords are excluded.
+func isIdent(s string) bool {
+	if s == "" || isKeyword(s) {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// fieldMetasKey identifies the cached field metadata of a struct type, which
+// depends on the tags consulted by the Encoder.
+type fieldMetasKey struct {
+	typ          reflect.Type
+	tag, metaTag string
+}
+
+// fieldMetasEntry is the cached result of extracting the field metadata of a
+// struct type.
+type fieldMetasEntry struct {
+	metas []fieldMeta
+	err   error
+}
+
+// fieldMetasCache holds a fieldMetasEntry for each fieldMetasKey encountered,
+// so that struct tags are only parsed once per type.
+var fieldMetasCache sync.Map
+
+// fieldMetas returns the metadata of each field of the struct type t, in
+// order. The result is cached and must not be modified.
+func (e *Encoder) fieldMetas(t reflect.Type) ([]fieldMeta, error) {
+	key := fieldMetasKey{typ: t, tag: e.tagName(), metaTag: e.metaTagName()}
+	if entry, ok := fieldMetasCache.Load(key); ok {
+		return entry.(fieldMetasEntry).metas, entry.(fieldMetasEntry).err
+	}
+
+	entry := fieldMetasEntry{metas: make([]fieldMeta, t.NumField())}
+	for i := range entry.metas {
+		field := t.Field(i)
+		meta, err := e.extractFieldMeta(field)
+		if err != nil {
+			entry = fieldMetasEntry{err: fmt.Errorf("field %q: %w", field.Name, err)}
+			break
+		}
+		entry.metas[i] = meta
+	}
+
+	fieldMetasCache.Store(key, entry)
+	return entry.metas, entry.err
+}
+
+// extractFieldMeta pulls information about struct fields and the optional HCL tags.
+// An error is returned if a tag directive has an invalid argument.
+func (e *Encoder) extractFieldMeta(f reflect.StructField) (meta fieldMeta, err error) {
 	if f.Anonymous {
 		meta.anonymous = true
 		meta.name = f.Type.Name()
@@ -359,7 +2049,19 @@ func extractFieldMeta(f reflect.StructField) (meta fieldMeta) {
 		meta.name = f.Name
 	}
 
-	tags := strings.Split(f.Tag.Get(HCLTagName), ",")
+	// like the decoder, skip unexported fields, except embedded structs whose
+	// exported fields are squashed into the parent
+	if !f.IsExported() && !f.Anonymous {
+		meta.omit = true
+		return
+	}
+
+	tags := strings.Split(f.Tag.Get(e.tagName()), ",")
+	if len(tags) == 1 && tags[0] == "-" {
+		meta.omit = true
+		return
+	}
+
 	if len(tags) > 0 {
 		if tags[0] != "" {
 			meta.name = tags[0]
@@ -375,23 +2077,101 @@ func extractFieldMeta(f reflect.StructField) (meta fieldMeta) {
 				meta.decodedFields = true
 			case UnusedKeysTag:
 				meta.unusedKeys = true
+			case ExprTag:
+				meta.expr = true
+			case DynamicTag:
+				meta.dynamic = true
+			case SetTag:
+				meta.set = true
+			case BlocksTag:
+				meta.blocks = true
+			case ObjectTag:
+				meta.object = true
+			default:
+				if name, arg := splitOption(tag); name == FileTag {
+					meta.file = arg
+				}
 			}
 		}
 	}
 
-	tags = strings.Split(f.Tag.Get(HCLETagName), ",")
+	tags = splitTag(f.Tag.Get(e.metaTagName()))
 	for _, tag := range tags {
+		tag, arg := splitDirective(tag)
 		switch tag {
 		case OmitTag:
 			meta.omit = true
 		case OmitEmptyTag:
 			meta.omitEmpty = true
+		case UnitTag:
+			meta.unit = arg
+		case Base64Tag:
+			meta.base64 = true
+		case FormatDateTag:
+			meta.formatDate = arg
+		case CommentTag:
+			meta.comment = arg
+		case InlineCommentTag:
+			meta.inlineComment = arg
+		case SplitTag:
+			meta.split = arg
+		case PresenceTag:
+			meta.presence = true
+		case ForEachTag:
+			meta.forEach = arg
+		case KeepNilTag:
+			meta.keepNil = true
+		case WithZoneTag:
+			meta.withZone = true
+		case LiteralTag:
+			meta.literal = true
+		case HeredocTag:
+			meta.heredoc = true
+		case AutoBoolTag:
+			meta.autoBool = true
+		case InheritLabelsTag:
+			meta.inheritLabels = true
+		case ConcatTag:
+			meta.concat = true
+		case StringerTag:
+			meta.stringer = true
+		case SensitiveTag:
+			meta.sensitive = true
+		case SectionTag:
+			meta.section = arg
+		case ToSetTag, ToListTag:
+			meta.convert = tag
+		case PrecisionTag:
+			p, err := strconv.Atoi(arg)
+			if err != nil || p < 0 {
+				return meta, fmt.Errorf("invalid precision %q, must be a non-negative integer", arg)
+			}
+			meta.precision, meta.hasPrecision = p, true
 		}
 	}
 
+	if meta.dynamic && meta.forEach == "" {
+		return meta, errors.New("dynamic blocks require a foreach expression")
+	}
+
+	if meta.expr && meta.literal {
+		return meta, fmt.Errorf("%s and %s cannot be used together", ExprTag, LiteralTag)
+	}
+
 	return
 }
 
+// isEmpty returns true if v is the zero value of its type, or a slice, map, or
+// string of length zero.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
 // deref safely dereferences interface and pointer values to their underlying value types.
 // It also detects if that value is invalid or nil.
 func deref(in reflect.Value) (val reflect.Value, isNil bool) {
@@ -411,6 +2191,132 @@ func deref(in reflect.Value) (val reflect.Value, isNil bool) {
 	}
 }
 
+// newLiteral returns an ast.LiteralType for tkn
+func newLiteral(tkn token.Token) *ast.LiteralType {
+	return &ast.LiteralType{Token: tkn}
+}
+
+// redactNode replaces the token of every literal in the tree rooted at n with
+// tkn, including those of composite values built without going through
+// encodeField (eg, withzone times and raw nodes).
+func redactNode(n ast.Node, tkn token.Token) {
+	ast.Walk(n, func(n ast.Node) (ast.Node, bool) {
+		if lit, ok := n.(*ast.LiteralType); ok {
+			lit.Token = tkn
+		}
+		return n, true
+	})
+}
+
+// textMarshaler returns the encoding.TextMarshaler implemented by in, if any.
+// Marshalers defined on the pointer receiver are also detected, copying the
+// value if it is not addressable.
+func textMarshaler(in reflect.Value) (encoding.TextMarshaler, bool) {
+	if !in.IsValid() || !in.CanInterface() {
+		return nil, false
+	}
+
+	if in.Type().Implements(textMarshalerType) {
+		return in.Interface().(encoding.TextMarshaler), true
+	}
+
+	if !reflect.PtrTo(in.Type()).Implements(textMarshalerType) {
+		return nil, false
+	}
+
+	return addr(in).Interface().(encoding.TextMarshaler), true
+}
+
+// jsonMarshaler returns the json.Marshaler implemented by in, if any, like
+// textMarshaler.
+func jsonMarshaler(in reflect.Value) (json.Marshaler, bool) {
+	if !in.IsValid() || !in.CanInterface() {
+		return nil, false
+	}
+
+	if in.Type().Implements(jsonMarshalerType) {
+		return in.Interface().(json.Marshaler), true
+	}
+
+	if !reflect.PtrTo(in.Type()).Implements(jsonMarshalerType) {
+		return nil, false
+	}
+
+	return addr(in).Interface().(json.Marshaler), true
+}
+
+// encodeJSONMarshaler converts the JSON produced by m into the equivalent
+// HCL, as if the JSON were decoded into an interface{}. Integers are kept as
+// such, beyond the range of int64 if needed, and the field formatting applies
+// to each value. A JSON null produces no node.
+func (e *Encoder) encodeJSONMarshaler(m json.Marshaler, meta fieldMeta) (ast.Node, []*ast.ObjectKey, error) {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err = d.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+
+	return e.encodeField(reflect.ValueOf(jsonNumbers(v)), meta)
+}
+
+// jsonNumbers replaces each json.Number within v with an int64, a *big.Int if
+// it is an integer out of range, or a float64.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return i
+		}
+		f, _ := v.Float64() // valid JSON numbers always parse
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = jsonNumbers(v[k])
+		}
+	}
+	return v
+}
+
+// isBytes returns true if t is a slice or array of bytes
+func isBytes(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// addr returns a pointer to in, copying the value if it is not addressable
+func addr(in reflect.Value) reflect.Value {
+	if in.CanAddr() {
+		return in.Addr()
+	}
+
+	ptr := reflect.New(in.Type())
+	ptr.Elem().Set(in)
+	return ptr
+}
+
+// implementsTextMarshaler returns true if t or a pointer to t implements
+// encoding.TextMarshaler.
+func implementsTextMarshaler(t reflect.Type) bool {
+	return t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)
+}
+
 type objectItems []*ast.ObjectItem
 
 func (ol objectItems) Len() int      { return len(ol) }
@@ -419,10 +2325,12 @@ func (ol objectItems) Less(i, j int) bool {
 	iKeys := ol[i].Keys
 	jKeys := ol[j].Keys
 	for k := 0; k < len(iKeys) && k < len(jKeys); k++ {
-		if iKeys[k].Token.Text == jKeys[k].Token.Text {
+		// compare the unquoted names, so quoted keys sort among bare ones
+		iName, jName := keyName(iKeys[k]), keyName(jKeys[k])
+		if iName == jName {
 			continue
 		}
-		return iKeys[k].Token.Text < jKeys[k].Token.Text
+		return iName < jName
 	}
 	return len(iKeys) <= len(jKeys)
 }
@@ -1,9 +1,17 @@
 package hclencoder
 
 import (
+	"database/sql"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/hcl/hcl/ast"
 	"github.com/hashicorp/hcl/hcl/token"
@@ -35,6 +43,14 @@ func (test encodeTest) Test(f encodeFunc, t *testing.T) (node ast.Node, key []*a
 	return
 }
 
+// withoutMeta adapts a field-aware encoding function to an encodeFunc using
+// the default field metadata.
+func withoutMeta(f func(reflect.Value, fieldMeta) (ast.Node, []*ast.ObjectKey, error)) encodeFunc {
+	return func(in reflect.Value) (ast.Node, []*ast.ObjectKey, error) {
+		return f(in, fieldMeta{})
+	}
+}
+
 func RunAll(tests []encodeTest, f encodeFunc, t *testing.T) {
 	for _, test := range tests {
 		test.Test(f, t)
@@ -96,9 +112,109 @@ func TestEncode(t *testing.T) {
 				},
 			}}},
 		},
+		{
+			ID:       "text marshaler",
+			Input:    reflect.ValueOf(TextStruct{"fizz"}),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"text:fizz"`}},
+		},
+		{
+			ID:       "text marshaler - pointer receiver",
+			Input:    reflect.ValueOf(PtrTextStruct{"buzz"}),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"ptr:buzz"`}},
+		},
+		{
+			ID:       "text marshaler - escaped",
+			Input:    reflect.ValueOf(TextStruct{`"quoted"`}),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"text:\"quoted\""`}},
+		},
+		{
+			ID:    "text marshaler - list",
+			Input: reflect.ValueOf([]PtrTextStruct{{"fizz"}, {"buzz"}}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"ptr:fizz"`}},
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"ptr:buzz"`}},
+			}},
+		},
+		{
+			ID:    "text marshaler - error",
+			Input: reflect.ValueOf(ErrTextStruct{}),
+			Error: true,
+		},
+		{
+			ID:       "net.IP",
+			Input:    reflect.ValueOf(net.ParseIP("10.0.0.1")),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"10.0.0.1"`}},
+		},
+		{
+			ID:       "net.IP - pointer",
+			Input:    reflect.ValueOf(&[]net.IP{net.ParseIP("::1")}[0]),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"::1"`}},
+		},
+		{
+			ID:    "net.IP - list",
+			Input: reflect.ValueOf([]net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"10.0.0.1"`}},
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"10.0.0.2"`}},
+			}},
+		},
+		{
+			ID:       "net.IPNet",
+			Input:    reflect.ValueOf(*mustParseCIDR("10.0.0.0/8")),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"10.0.0.0/8"`}},
+		},
+		{
+			ID:       "net.IPNet - pointer",
+			Input:    reflect.ValueOf(mustParseCIDR("fd00::/8")),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"fd00::/8"`}},
+		},
+		{
+			ID:    "net.IPNet - list",
+			Input: reflect.ValueOf([]*net.IPNet{mustParseCIDR("10.0.0.0/8"), mustParseCIDR("192.168.0.0/16")}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"10.0.0.0/8"`}},
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"192.168.0.0/16"`}},
+			}},
+		},
+		{
+			ID:       "url.URL",
+			Input:    reflect.ValueOf(*mustParseURL("https://example.com/path?q=1")),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"https://example.com/path?q=1"`}},
+		},
+		{
+			ID:       "url.URL - pointer",
+			Input:    reflect.ValueOf(mustParseURL("s3://bucket/key")),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"s3://bucket/key"`}},
+		},
+		{
+			ID:       "regexp.Regexp",
+			Input:    reflect.ValueOf(regexp.MustCompile(`^[a-z]+\.example\.com$`)),
+			Expected: &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"^[a-z]+\\.example\\.com$"`}},
+		},
+		{
+			ID:    "regexp.Regexp - list",
+			Input: reflect.ValueOf([]*regexp.Regexp{regexp.MustCompile(`a+`), regexp.MustCompile(`(?i)b`)}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a+"`}},
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"(?i)b"`}},
+			}},
+		},
+		{
+			ID:    "url.URL - list",
+			Input: reflect.ValueOf([]*url.URL{mustParseURL("http://a"), mustParseURL("http://b")}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"http://a"`}},
+				&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"http://b"`}},
+			}},
+		},
 	}
 
-	RunAll(tests, encode, t)
+	RunAll(tests, new(Encoder).encode, t)
+}
+
+func TestEncode_TextMarshalerErrorField(t *testing.T) {
+	_, _, err := new(Encoder).encode(reflect.ValueOf(struct{ Foo ErrTextStruct }{}))
+	assert.EqualError(t, err, `field "Foo": unable to marshal hclencoder.ErrTextStruct as text: boom`)
 }
 
 func TestEncodePrimitive(t *testing.T) {
@@ -120,7 +236,7 @@ func TestEncodePrimitive(t *testing.T) {
 		},
 	}
 
-	RunAll(tests, encodePrimitive, t)
+	RunAll(tests, withoutMeta(new(Encoder).encodePrimitive), t)
 }
 
 func TestEncodeList(t *testing.T) {
@@ -163,6 +279,32 @@ func TestEncodeList(t *testing.T) {
 			}},
 			//Error: true,
 		},
+		{
+			ID:    "array - primitive",
+			Input: reflect.ValueOf([3]float64{1.5, -2, 0}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "1.5"}},
+				&ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "-2"}},
+				&ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "0"}},
+			}},
+		},
+		{
+			ID:       "array - empty",
+			Input:    reflect.ValueOf([0]string{}),
+			Expected: &ast.ListType{List: []ast.Node{}},
+		},
+		{
+			ID:    "array - block",
+			Input: reflect.ValueOf([1]TestStruct{{Bar: "fizzbuzz"}}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.ObjectType{List: &ast.ObjectList{
+					Items: []*ast.ObjectItem{{
+						Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
+						Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"fizzbuzz"`}},
+					}},
+				}},
+			}},
+		},
 		{
 			ID:    "block",
 			Input: reflect.ValueOf([]TestStruct{{}, {Bar: "fizzbuzz"}}),
@@ -222,6 +364,24 @@ func TestEncodeList(t *testing.T) {
 				}},
 			}},
 		},
+		{
+			ID:    "block - bool fields",
+			Input: reflect.ValueOf([]ToggleStruct{{Enabled: true}}),
+			Expected: &ast.ListType{List: []ast.Node{
+				&ast.ObjectType{List: &ast.ObjectList{
+					Items: []*ast.ObjectItem{
+						{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "enabled"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "true"}},
+						},
+						{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "debug"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "false"}},
+						},
+					},
+				}},
+			}},
+		},
 		{
 			ID:    "block - key field",
 			Input: reflect.ValueOf([]KeyStruct{{Bar: "foo"}}),
@@ -237,7 +397,21 @@ func TestEncodeList(t *testing.T) {
 		},
 	}
 
-	RunAll(tests, encodeList, t)
+	RunAll(tests, withoutMeta(new(Encoder).encodeList), t)
+}
+
+func TestEncodeList_EmitNull(t *testing.T) {
+	test := encodeTest{
+		ID:    "primitive - nil item as null",
+		Input: reflect.ValueOf([]*string{strAddr("a"), nil, strAddr("b")}),
+		Expected: &ast.ListType{List: []ast.Node{
+			&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+			&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "null"}},
+			&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"b"`}},
+		}},
+	}
+
+	test.Test(withoutMeta((&Encoder{EmitNull: true}).encodeList), t)
 }
 
 func TestEncodeMap(t *testing.T) {
@@ -256,11 +430,140 @@ func TestEncodeMap(t *testing.T) {
 				},
 			}}},
 		},
+		{
+			ID:    "non-identifier keys",
+			Input: reflect.ValueOf(map[string]int{"foo-bar": 1, "foo.bar": 2, "1foo": 3, "foo bar": 4}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"1foo"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "3"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"foo bar"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "4"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "foo-bar"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "foo.bar"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "2"}},
+				},
+			}}},
+		},
+		{
+			ID:    "mixed interface values",
+			Input: reflect.ValueOf(map[string]interface{}{"bool": true, "int": 42, "float": 1.5, "string": "foo"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "bool"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "true"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "float"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "1.5"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "int"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "42"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "string"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+				},
+			}}},
+		},
+		{
+			ID: "expr values",
+			Input: reflect.ValueOf(map[string]interface{}{
+				"count": Expr("var.replicas"),
+				"name":  "web",
+				"zones": []interface{}{Expr("var.zone"), "us-east-1a"},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "count"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.replicas"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "name"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"web"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zones"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.zone"}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"us-east-1a"`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:    "keyword key",
+			Input: reflect.ValueOf(map[string]int{"for": 1}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"for"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}},
+				},
+			}}},
+		},
 		{
 			ID:    "invalid key",
 			Input: reflect.ValueOf(map[int]string{}),
 			Error: true,
 		},
+		{
+			ID: "raw node values",
+			Input: reflect.ValueOf(map[string]interface{}{
+				"key":  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}},
+				"keys": []interface{}{&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}}, "foo"},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "key"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "keys"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `file("id_rsa.pub")`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:    "raw node values - unsupported",
+			Input: reflect.ValueOf(map[string]interface{}{"key": &ast.ObjectKey{}}),
+			Error: true,
+		},
+		{
+			ID:    "interface keys",
+			Input: reflect.ValueOf(map[interface{}]string{"a": "1"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "a"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"1"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "invalid interface key",
+			Input: reflect.ValueOf(map[interface{}]string{1: "1"}),
+			Error: true,
+		},
+		{
+			ID:    "nil interface key",
+			Input: reflect.ValueOf(map[interface{}]string{nil: "1"}),
+			Error: true,
+		},
+		{
+			ID:    "pointer interface key",
+			Input: reflect.ValueOf(map[interface{}]string{new(string): "x", "ok": "y"}),
+			Error: true,
+		},
 		{
 			ID:    "invalid value",
 			Input: reflect.ValueOf(map[string]InvalidStruct{"foo": InvalidStruct{}}),
@@ -284,6 +587,26 @@ func TestEncodeMap(t *testing.T) {
 				},
 			}}},
 		},
+		{
+			ID:    "key field - pointer",
+			Input: reflect.ValueOf(map[string]*KeyStruct{"fizz": {Bar: "buzz"}, "foo": {Bar: "bar baz"}, "nil": nil}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{
+						{Token: token.Token{Type: token.IDENT, Text: "fizz"}},
+						{Token: token.Token{Type: token.STRING, Text: `"buzz"`}},
+					},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{
+						{Token: token.Token{Type: token.IDENT, Text: "foo"}},
+						{Token: token.Token{Type: token.STRING, Text: `"bar baz"`}},
+					},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+				},
+			}}},
+		},
 		{
 			ID: "keyed list",
 			Input: reflect.ValueOf(map[string][]map[string]interface{}{
@@ -335,7 +658,7 @@ func TestEncodeMap(t *testing.T) {
 		},
 	}
 
-	RunAll(tests, encodeMap, t)
+	RunAll(tests, withoutMeta(new(Encoder).encodeMap), t)
 }
 
 func TestEncodeStruct(t *testing.T) {
@@ -360,6 +683,11 @@ func TestEncodeStruct(t *testing.T) {
 			Input:    reflect.ValueOf(OmitStruct{"foo"}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
 		},
+		{
+			ID:       "omit field - dash",
+			Input:    reflect.ValueOf(DashOmitStruct{"foo"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+		},
 		{
 			ID:       "omitempty field - empty",
 			Input:    reflect.ValueOf(OmitEmptyStruct{}),
@@ -376,111 +704,893 @@ func TestEncodeStruct(t *testing.T) {
 			}}},
 		},
 		{
-			ID:       "nil field",
-			Input:    reflect.ValueOf(NillableStruct{}),
+			ID:       "omitempty field - nil collections",
+			Input:    reflect.ValueOf(OmitEmptyCollectionStruct{}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
 		},
 		{
-			ID:    "invalid key type",
-			Input: reflect.ValueOf(InvalidKeyStruct{123}),
-			Error: true,
+			ID:       "omitempty field - empty collections",
+			Input:    reflect.ValueOf(OmitEmptyCollectionStruct{List: []string{}, Map: map[string]string{}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
 		},
 		{
-			ID:    "squash anonymous field",
-			Input: reflect.ValueOf(SquashStruct{TestStruct: TestStruct{"foo"}}),
+			ID:    "omitempty field - non-empty collections",
+			Input: reflect.ValueOf(OmitEmptyCollectionStruct{List: []string{"a"}, Map: map[string]string{"b": "c"}}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
 				&ast.ObjectItem{
-					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
-					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Map"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "b"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"c"`}},
+						},
+					}}},
 				},
 			}}},
 		},
 		{
-			ID:    "keyed child struct",
-			Input: reflect.ValueOf(KeyChildStruct{Foo: KeyStruct{Bar: "baz"}}),
+			ID:       "omitempty field - empty nested types",
+			Input:    reflect.ValueOf(OmitEmptyNestedStruct{}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+		},
+		{
+			ID:    "omitempty field - nested type with map",
+			Input: reflect.ValueOf(OmitEmptyNestedStruct{Inner: MapHolder{Tags: map[string]string{}}}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
 				&ast.ObjectItem{
-					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}, {Token: token.Token{Type: token.STRING, Text: `"baz"`}}},
-					Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Inner"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Tags"}}},
+							Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+						},
+					}}},
 				},
 			}}},
 		},
 		{
-			ID:    "squash keyed child struct",
-			Input: reflect.ValueOf(SquashKeyChildStruct{KeyChildStruct: KeyChildStruct{Foo: KeyStruct{Bar: "baz"}}}),
+			ID:    "withzone field",
+			Input: reflect.ValueOf(ZonedTimeStruct{time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*60*60))}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
 				&ast.ObjectItem{
-					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}, {Token: token.Token{Type: token.STRING, Text: `"baz"`}}},
-					Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "at"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "time"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"2020-01-02T03:04:05-05:00"`}},
+						},
+						{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zone"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"EST"`}},
+						},
+					}}},
 				},
 			}}},
 		},
 		{
-			ID:    "nested unkeyed struct slice",
-			Input: reflect.ValueOf(struct{ Foo []TestStruct }{[]TestStruct{{"Test"}}}),
+			ID:    "keepnil field",
+			Input: reflect.ValueOf(KeepNilStruct{}),
 			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
 				&ast.ObjectItem{
-					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}},
-					Val: &ast.ListType{List: []ast.Node{&ast.ObjectType{
-						List: &ast.ObjectList{
-							Items: []*ast.ObjectItem{{
-								Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
-								Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"Test"`}},
-							}},
-						}}},
-					},
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Ptr"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "null"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "null"}},
 				},
 			}}},
 		},
-	}
-
-	RunAll(tests, encodeStruct, t)
-}
-
-func TestTokenize(t *testing.T) {
-	is := assert.New(t)
-
-	tests := []struct {
-		ID       string
-		Input    reflect.Value
-		Ident    bool
-		Expected token.Token
-		Err      bool
-	}{
 		{
-			"bool",
-			reflect.ValueOf(true),
-			false,
-			token.Token{Type: token.BOOL, Text: "true"},
-			false,
+			ID:    "keepnil field - not nil",
+			Input: reflect.ValueOf(KeepNilStruct{Ptr: strAddr("foo"), Omitted: strAddr("bar")}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Ptr"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "null"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Omitted"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"bar"`}},
+				},
+			}}},
 		},
 		{
-			"int",
-			reflect.ValueOf(123),
-			false,
-			token.Token{Type: token.NUMBER, Text: "123"},
-			false,
+			ID:       "nil field",
+			Input:    reflect.ValueOf(NillableStruct{}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
 		},
 		{
-			"float",
-			reflect.ValueOf(float64(4.56)),
-			false,
-			token.Token{Type: token.FLOAT, Text: "4.56"},
-			false,
+			ID:       "nil slice and map fields",
+			Input:    reflect.ValueOf(NillableCollectionStruct{}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
 		},
 		{
-			"float - superfluous",
-			reflect.ValueOf(float64(78.9000000000)),
-			false,
-			token.Token{Type: token.FLOAT, Text: "78.9"},
-			false,
+			ID:    "empty slice and map fields",
+			Input: reflect.ValueOf(NillableCollectionStruct{List: []string{}, Map: map[string]string{}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val:  &ast.ListType{List: []ast.Node{}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Map"}}},
+					Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+				},
+			}}},
+		},
+		{
+			ID:    "keyword field name",
+			Input: reflect.ValueOf(KeywordStruct{"bar"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"for"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"bar"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "non-identifier field names",
+			Input: reflect.ValueOf(NonIdentStruct{"a", "b", "c", "d"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "foo-bar"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "foo"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "bar"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"b"`}},
+						},
+					}}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"1foo"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"c"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"foo bar"`}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"d"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "expr field",
+			Input: reflect.ValueOf(ExprStruct{Count: "var.count", Env: map[string]string{"HOME": "var.home"}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Count"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.count"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Env"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "HOME"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.home"}},
+						},
+					}}},
+				},
+			}}},
+		},
+		{
+			ID:    "expr field - empty",
+			Input: reflect.ValueOf(struct{ Foo Expr }{}),
+			Error: true,
+		},
+		{
+			ID:    "unit field",
+			Input: reflect.ValueOf(UnitStruct{Memory: 512, Sizes: []int{1, 2}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Memory"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"512Mi"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Sizes"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"1Gi"`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"2Gi"`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:    "precision field",
+			Input: reflect.ValueOf(PrecisionStruct{Cost: 4.5, Latencies: []float64{1, 2.345}, Memory: 1.5}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Cost"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "4.50"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Latencies"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "1.0"}},
+						&ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "2.3"}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Memory"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"1.50Gi"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "big.Rat field",
+			Input: reflect.ValueOf(RatStruct{Fraction: big.NewRat(2, 3), Decimal: *big.NewRat(2, 3), Whole: big.NewRat(4, 2)}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Fraction"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"2/3"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Decimal"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"0.667"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Whole"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"2"`}},
+				},
+			}}},
+		},
+		{
+			ID: "big.Int and big.Float fields",
+			Input: reflect.ValueOf(BigStruct{
+				Int:      mustParseBigInt("123456789012345678901234567890"),
+				Negative: *mustParseBigInt("-9223372036854775809"),
+				Float:    mustParseBigFloat("12345678901234567890.125"),
+				Rounded:  mustParseBigFloat("2.5"),
+				List:     []*big.Int{big.NewInt(1), mustParseBigInt("18446744073709551616")},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Int"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "123456789012345678901234567890"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Negative"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "-9223372036854775809"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Float"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "1.2345678901234567890125e+19"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Rounded"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "2.50"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}},
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "18446744073709551616"}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:       "big.Int and big.Float fields - nil",
+			Input:    reflect.ValueOf(BigStruct{}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+		},
+		{
+			ID:    "big.Float field - infinite",
+			Input: reflect.ValueOf(BigStruct{Float: new(big.Float).SetInf(false)}),
+			Error: true,
+		},
+		{
+			ID: "sql null fields - valid",
+			Input: reflect.ValueOf(SQLNullStruct{
+				Name:   sql.NullString{String: "", Valid: true},
+				Age:    sql.NullInt64{Int64: 42, Valid: true},
+				Active: sql.NullBool{Bool: true, Valid: true},
+				Score:  &sql.NullFloat64{Float64: 1.5, Valid: true},
+				Tags:   []sql.NullString{{String: "a", Valid: true}, {}, {String: "b", Valid: true}},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "name"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `""`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "age"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "42"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "active"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "true"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "score"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.FLOAT, Text: "1.5"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "tags"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"b"`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:    "sql null fields - invalid",
+			Input: reflect.ValueOf(SQLNullStruct{Name: sql.NullString{String: "ignored"}, Score: &sql.NullFloat64{}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "active"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "null"}},
+				},
+			}}},
+		},
+		{
+			ID: "bytes fields",
+			Input: reflect.ValueOf(BytesStruct{
+				Raw:     []byte("hi"),
+				Encoded: []byte("hi"),
+				Array:   [2]byte{'h', 'i'},
+				Named:   Blob("hi"),
+				List:    [][]byte{[]byte("hi"), []byte("there")},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Raw"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"hi"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Encoded"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"aGk="`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Array"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"aGk="`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Named"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"hi"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "List"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"aGk="`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"dGhlcmU="`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID: "formatdate fields",
+			Input: reflect.ValueOf(FormatDateStruct{
+				Expires: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				Plain:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "expires"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `formatdate("DD MMM YYYY", "2020-01-02T03:04:05Z")`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "plain"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"2020-01-02T03:04:05Z"`}},
+				},
+			}}},
+		},
+		{
+			ID: "formatdate field - not a time",
+			Input: reflect.ValueOf(struct {
+				Expires string `hcle:"formatdate:YYYY"`
+			}{"2020"}),
+			Error: true,
+		},
+		{
+			ID:    "bytes field - invalid UTF-8",
+			Input: reflect.ValueOf(struct{ Raw []byte }{[]byte{0xff}}),
+			Error: true,
+		},
+		{
+			ID:    "precision field - invalid",
+			Input: reflect.ValueOf(InvalidPrecisionStruct{}),
+			Error: true,
+		},
+		{
+			ID: "precision field - not float",
+			Input: reflect.ValueOf(struct {
+				Count int `hcle:"precision:2"`
+			}{}),
+			Error: true,
+		},
+		{
+			ID:    "unit field - not numeric",
+			Input: reflect.ValueOf(InvalidUnitStruct{"foo"}),
+			Error: true,
+		},
+		{
+			ID: "literal field",
+			Input: reflect.ValueOf(LiteralStruct{
+				Template: "${x}",
+				Partial:  `cost: $5 ${y`,
+				Plain:    "${x}",
+				Env:      map[string]string{"HOME": "${home}/bin"},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "template"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"$${x}"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "partial"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"cost: $5 $${y"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "plain"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"${x}"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "env"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "HOME"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"$${home}/bin"`}},
+						},
+					}}},
+				},
+			}}},
+		},
+		{
+			ID: "literal field - not string",
+			Input: reflect.ValueOf(struct {
+				Count int `hcle:"literal"`
+			}{}),
+			Error: true,
+		},
+		{
+			ID: "literal field - with expr",
+			Input: reflect.ValueOf(struct {
+				Ref string `hcl:",expr" hcle:"literal"`
+			}{"var.x"}),
+			Error: true,
+		},
+		{
+			ID:    "stringer field",
+			Input: reflect.ValueOf(StringerStruct{Color: Green, Colors: []Color{Red, Green}, Count: Red}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "color"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"green"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "colors"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"red"`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"green"`}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "count"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "0"}},
+				},
+			}}},
+		},
+		{
+			ID: "stringer field - not a stringer",
+			Input: reflect.ValueOf(struct {
+				Count int `hcle:"stringer"`
+			}{}),
+			Error: true,
+		},
+		{
+			ID:    "autobool field",
+			Input: reflect.ValueOf(AutoBoolStruct{Enabled: "true", Debug: "false", Mode: "True", Flags: []string{"true", "yes"}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "enabled"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "true"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "debug"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "false"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "mode"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"True"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "flags"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.BOOL, Text: "true"}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"yes"`}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID: "autobool field - not string",
+			Input: reflect.ValueOf(struct {
+				Enabled bool `hcle:"autobool"`
+			}{true}),
+			Error: true,
+		},
+		{
+			ID:    "invalid key type",
+			Input: reflect.ValueOf(InvalidKeyStruct{123}),
+			Error: true,
+		},
+		{
+			ID:    "squash anonymous field",
+			Input: reflect.ValueOf(SquashStruct{TestStruct: TestStruct{"foo"}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "squash anonymous pointer field",
+			Input: reflect.ValueOf(SquashPtrStruct{TestStruct: &TestStruct{"foo"}, Baz: "bar"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"foo"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Baz"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"bar"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "squash anonymous pointer field - nil",
+			Input: reflect.ValueOf(SquashPtrStruct{Baz: "bar"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Baz"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"bar"`}},
+				},
+			}}},
+		},
+		{
+			ID:    "presence field - true",
+			Input: reflect.ValueOf(PresenceStruct{Feature: true}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "feature"}}},
+					Val:  &ast.ObjectType{List: &ast.ObjectList{}},
+				},
+			}}},
+		},
+		{
+			ID:       "presence field - false",
+			Input:    reflect.ValueOf(PresenceStruct{}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+		},
+		{
+			ID:    "presence field - not a bool",
+			Input: reflect.ValueOf(InvalidPresenceStruct{"yes"}),
+			Error: true,
+		},
+		{
+			ID:    "set field",
+			Input: reflect.ValueOf(SetStruct{Tags: map[string]struct{}{"c": {}, "a": {}, "b": {}}, Ports: map[int]struct{}{443: {}, 80: {}}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "tags"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"b"`}},
+						&ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"c"`}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "ports"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "80"}},
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "443"}},
+					}},
+				},
+			}}},
+		},
+		{
+			ID:    "set field - non-empty struct values",
+			Input: reflect.ValueOf(InvalidSetStruct{Tags: map[string]bool{"a": true}}),
+			Error: true,
+		},
+		{
+			ID:    "toset field",
+			Input: reflect.ValueOf(ConvertStruct{Zones: []string{"a", "b"}, Ports: []int{80}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zones"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `toset(["a", "b"])`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "ports"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `tolist([80])`}},
+				},
+			}}},
+		},
+		{
+			ID:    "toset field - set",
+			Input: reflect.ValueOf(ConvertSetStruct{Tags: map[string]struct{}{"b": {}, "a": {}}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "tags"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `toset(["a", "b"])`}},
+				},
+			}}},
+		},
+		{
+			ID: "concat field",
+			Input: reflect.ValueOf(ConcatStruct{
+				Zones: [][]string{{"a"}, nil, {"b", "c"}},
+				Ports: []interface{}{Expr("var.ports"), []int{80}},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zones"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `concat(["a"], ["b", "c"])`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "ports"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: `toset(concat(var.ports, [80]))`}},
+				},
+			}}},
+		},
+		{
+			ID: "concat field - not lists",
+			Input: reflect.ValueOf(struct {
+				Zones []interface{} `hcle:"concat"`
+			}{[]interface{}{[]string{"a"}, "b"}}),
+			Error: true,
+		},
+		{
+			ID: "object field",
+			Input: reflect.ValueOf(PairsStruct{Tags: []Pair{
+				{Key: "zeta", Value: "z"},
+				{Key: "alpha", Value: "a"},
+				{Key: "has space", Value: "s"},
+			}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "tags"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zeta"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"z"`}},
+						},
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "alpha"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"a"`}},
+						},
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"has space"`}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"s"`}},
+						},
+					}}},
+				},
+			}}},
+		},
+		{
+			ID:    "object field - duplicate keys",
+			Input: reflect.ValueOf(PairsStruct{Tags: []Pair{{Key: "a"}, {Key: "a"}}}),
+			Error: true,
+		},
+		{
+			ID: "object field - not pairs",
+			Input: reflect.ValueOf(struct {
+				Tags []TestStruct `hcl:"tags,object"`
+			}{[]TestStruct{{"a"}}}),
+			Error: true,
+		},
+		{
+			ID:    "toset field - blocks",
+			Input: reflect.ValueOf(InvalidConvertStruct{Items: []TestStruct{{"a"}}}),
+			Error: true,
+		},
+		{
+			ID: "raw expression fields",
+			Input: reflect.ValueOf(RawExpressionStruct{
+				Count: "var.replicas",
+				Zones: []RawExpression{"var.zone", `element(var.zones, 1)`},
+				Env:   map[string]RawExpression{"HOME": "local.home"},
+			}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "count"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.replicas"}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "zones"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "var.zone"}},
+						&ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "element(var.zones, 1)"}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "env"}}},
+					Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+						&ast.ObjectItem{
+							Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "HOME"}}},
+							Val:  &ast.LiteralType{Token: token.Token{Type: token.IDENT, Text: "local.home"}},
+						},
+					}}},
+				},
+			}}},
+		},
+		{
+			ID:    "array fields",
+			Input: reflect.ValueOf(ArrayStruct{Coords: [3]int{1, 2, 3}, ID: [4]byte{0xde, 0xad, 0xbe, 0xef}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "coords"}}},
+					Val: &ast.ListType{List: []ast.Node{
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}},
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "2"}},
+						&ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "3"}},
+					}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "id"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"3q2+7w=="`}},
+				},
+			}}},
+		},
+		{
+			ID:    "key field declared last",
+			Input: reflect.ValueOf(TrailingKeyStruct{Foo: "bar", Baz: 1, Name: "fizz"}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "foo"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"bar"`}},
+				},
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "baz"}}},
+					Val:  &ast.LiteralType{Token: token.Token{Type: token.NUMBER, Text: "1"}},
+				},
+			}}},
+			Key: []*ast.ObjectKey{{Token: token.Token{Type: token.STRING, Text: `"fizz"`}}},
+		},
+		{
+			ID:    "keyed child struct",
+			Input: reflect.ValueOf(KeyChildStruct{Foo: KeyStruct{Bar: "baz"}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}, {Token: token.Token{Type: token.STRING, Text: `"baz"`}}},
+					Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+				},
+			}}},
+		},
+		{
+			ID:    "squash keyed child struct",
+			Input: reflect.ValueOf(SquashKeyChildStruct{KeyChildStruct: KeyChildStruct{Foo: KeyStruct{Bar: "baz"}}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}, {Token: token.Token{Type: token.STRING, Text: `"baz"`}}},
+					Val:  &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{}}},
+				},
+			}}},
+		},
+		{
+			ID:    "nested unkeyed struct slice",
+			Input: reflect.ValueOf(struct{ Foo []TestStruct }{[]TestStruct{{"Test"}}}),
+			Expected: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{
+				&ast.ObjectItem{
+					Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Foo"}}},
+					Val: &ast.ListType{List: []ast.Node{&ast.ObjectType{
+						List: &ast.ObjectList{
+							Items: []*ast.ObjectItem{{
+								Keys: []*ast.ObjectKey{{Token: token.Token{Type: token.IDENT, Text: "Bar"}}},
+								Val:  &ast.LiteralType{Token: token.Token{Type: token.STRING, Text: `"Test"`}},
+							}},
+						}}},
+					},
+				},
+			}}},
+		},
+	}
+
+	RunAll(tests, new(Encoder).encodeStruct, t)
+}
+
+func TestTokenize(t *testing.T) {
+	is := assert.New(t)
+
+	tests := []struct {
+		ID       string
+		Input    reflect.Value
+		Ident    bool
+		Expected token.Token
+		Err      bool
+	}{
+		{
+			"bool",
+			reflect.ValueOf(true),
+			false,
+			token.Token{Type: token.BOOL, Text: "true"},
+			false,
+		},
+		{
+			"int",
+			reflect.ValueOf(123),
+			false,
+			token.Token{Type: token.NUMBER, Text: "123"},
+			false,
+		},
+		{
+			"float",
+			reflect.ValueOf(float64(4.56)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "4.56"},
+			false,
+		},
+		{
+			"float - superfluous",
+			reflect.ValueOf(float64(78.9000000000)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "78.9"},
+			false,
+		},
+		{
+			"float - whole number",
+			reflect.ValueOf(float64(1234567890)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "1234567890"},
+			false,
 		},
 		{
-			"float - scientific notation",
-			reflect.ValueOf(float64(1234567890)),
+			"float - negative whole number",
+			reflect.ValueOf(float64(-9007199254740992)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "-9007199254740992"},
+			false,
+		},
+		{
+			"float - scientific notation - large",
+			reflect.ValueOf(float64(1e21)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "1e+21"},
+			false,
+		},
+		{
+			"float - scientific notation - small",
+			reflect.ValueOf(float64(1.5e-7)),
+			false,
+			token.Token{Type: token.FLOAT, Text: "1.5e-07"},
+			false,
+		},
+		{
+			"float - NaN",
+			reflect.ValueOf(math.NaN()),
+			false,
+			token.Token{},
+			true,
+		},
+		{
+			"float - positive infinity",
+			reflect.ValueOf(math.Inf(1)),
 			false,
-			token.Token{Type: token.FLOAT, Text: "1.23456789e+09"},
+			token.Token{},
+			true,
+		},
+		{
+			"float - negative infinity",
+			reflect.ValueOf(math.Inf(-1)),
 			false,
+			token.Token{},
+			true,
 		},
 		{
 			"string",
@@ -489,6 +1599,13 @@ func TestTokenize(t *testing.T) {
 			token.Token{Type: token.STRING, Text: `"foobar"`},
 			false,
 		},
+		{
+			"string - escaped",
+			reflect.ValueOf("foo \"bar\"\n"),
+			false,
+			token.Token{Type: token.STRING, Text: `"foo \"bar\"\n"`},
+			false,
+		},
 		{
 			"ident",
 			reflect.ValueOf("fizzbuzz"),
@@ -496,6 +1613,13 @@ func TestTokenize(t *testing.T) {
 			token.Token{Type: token.IDENT, Text: "fizzbuzz"},
 			false,
 		},
+		{
+			"ident - keyword",
+			reflect.ValueOf("for"),
+			true,
+			token.Token{Type: token.STRING, Text: `"for"`},
+			false,
+		},
 	}
 
 	for _, test := range tests {
@@ -509,6 +1633,63 @@ func TestTokenize(t *testing.T) {
 	}
 }
 
+func TestEscapeString(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Expected string
+	}{
+		{"foo", "foo"},
+		{`"quoted"`, `\"quoted\"`},
+		{`back\slash`, `back\\slash`},
+		{"new\nline\ttab", `new\nline\ttab`},
+		{"bell\a", `bell\u0007`},
+		{`${lookup(var.foo, "bar")}`, `${lookup(var.foo, "bar")}`},
+		{`"${var.foo}"`, `\"${var.foo}\"`},
+		{`$${"literal"}`, `$${"literal"}`},
+		{`${map("a", "b")["a"]} "x"`, `${map("a", "b")["a"]} \"x\"`},
+		{`trailing\`, `trailing\\`},
+		{`\`, `\\`},
+		{`C:\path\"dir"`, `C:\\path\\\"dir\"`},
+		{`say "hi" to ${var.name}`, `say \"hi\" to ${var.name}`},
+		{`${unterminated "x"`, `\u0024{unterminated \"x\"`},
+		{`${a} "${b"`, `${a} \"\u0024{b\"`},
+		{`$$`, `$$`},
+		{`{"json": true}`, `{\"json\": true}`},
+		{`é${var.x}`, `é${var.x}`},
+		{`🚀${var.x}"`, `🚀${var.x}\"`},
+		{`日本${"é"}語`, `日本${"é"}語`},
+		{`ü${unterminated "😀"`, `ü\u0024{unterminated \"😀\"`},
+		{`café$`, `café$`},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, EscapeString(test.Input), test.Input)
+	}
+}
+
+func TestIsIdent(t *testing.T) {
+	tests := map[string]bool{
+		"foo":     true,
+		"_foo":    true,
+		"foo_bar": true,
+		"foo-bar": true,
+		"foo.bar": true,
+		"foo1":    true,
+		"héllo":   true,
+		"":        false,
+		"1foo":    false,
+		"-foo":    false,
+		".foo":    false,
+		"foo bar": false,
+		"foo/bar": false,
+		"true":    false,
+	}
+
+	for in, expected := range tests {
+		assert.Equal(t, expected, isIdent(in), in)
+	}
+}
+
 func TestExtractFieldMeta(t *testing.T) {
 	is := assert.New(t)
 
@@ -517,34 +1698,192 @@ func TestExtractFieldMeta(t *testing.T) {
 	tests := []struct {
 		Tag      string
 		Expected fieldMeta
+		Error    bool
 	}{
 		{
 			"",
 			fieldMeta{name: fieldName},
+			false,
 		},
 		{
 			`hcl:"bar"`,
 			fieldMeta{name: "bar"},
+			false,
+		},
+		{
+			`hcl:"-"`,
+			fieldMeta{name: fieldName, omit: true},
+			false,
+		},
+		{
+			`hcl:"-,"`,
+			fieldMeta{name: "-"},
+			false,
+		},
+		{
+			`hcl:"-,key"`,
+			fieldMeta{name: "-", key: true},
+			false,
 		},
 		{
 			`hcl:"bar,key"`,
 			fieldMeta{name: "bar", key: true},
+			false,
+		},
+		{
+			`hcle:"comment:foo: bar\\, baz,omitempty"`,
+			fieldMeta{name: fieldName, comment: "foo: bar, baz", omitEmpty: true},
+			false,
+		},
+		{
+			`hcle:"presence"`,
+			fieldMeta{name: fieldName, presence: true},
+			false,
+		},
+		{
+			`hcle:"withzone"`,
+			fieldMeta{name: fieldName, withZone: true},
+			false,
+		},
+		{
+			`hcle:"keepnil"`,
+			fieldMeta{name: fieldName, keepNil: true},
+			false,
+		},
+		{
+			`hcle:"split:foo"`,
+			fieldMeta{name: fieldName, split: "foo"},
+			false,
+		},
+		{
+			`hcle:"inlinecomment:foo"`,
+			fieldMeta{name: fieldName, inlineComment: "foo"},
+			false,
 		},
 		{
 			`hcl:",squash"`,
 			fieldMeta{name: fieldName, squash: true},
+			false,
+		},
+		{
+			`hcl:",expr"`,
+			fieldMeta{name: fieldName, expr: true},
+			false,
 		},
 		{
 			`hcl:",decodedFields,unusedKeys"`,
 			fieldMeta{name: fieldName, decodedFields: true, unusedKeys: true},
+			false,
 		},
 		{
 			`hcl:",key" hcle:"omit"`,
 			fieldMeta{name: fieldName, key: true, omit: true},
+			false,
 		},
 		{
 			`hcle:"omitempty"`,
 			fieldMeta{name: fieldName, omitEmpty: true},
+			false,
+		},
+		{
+			`hcle:"omitempty,unit:Mi"`,
+			fieldMeta{name: fieldName, omitEmpty: true, unit: "Mi"},
+			false,
+		},
+		{
+			`hcle:"precision:2"`,
+			fieldMeta{name: fieldName, precision: 2, hasPrecision: true},
+			false,
+		},
+		{
+			`hcle:"precision:0"`,
+			fieldMeta{name: fieldName, precision: 0, hasPrecision: true},
+			false,
+		},
+		{
+			`hcle:"formatdate:DD MMM YYYY"`,
+			fieldMeta{name: fieldName, formatDate: "DD MMM YYYY"},
+			false,
+		},
+		{
+			`hcle:"precision:two"`,
+			fieldMeta{},
+			true,
+		},
+		{
+			`hcl:",dynamic" hcle:"foreach:var.foo"`,
+			fieldMeta{name: fieldName, dynamic: true, forEach: "var.foo"},
+			false,
+		},
+		{
+			`hcl:",dynamic"`,
+			fieldMeta{},
+			true,
+		},
+		{
+			`hcle:"toset"`,
+			fieldMeta{name: fieldName, convert: "toset"},
+			false,
+		},
+		{
+			`hcle:"literal"`,
+			fieldMeta{name: fieldName, literal: true},
+			false,
+		},
+		{
+			`hcle:"heredoc,literal"`,
+			fieldMeta{name: fieldName, heredoc: true, literal: true},
+			false,
+		},
+		{
+			`hcle:"autobool"`,
+			fieldMeta{name: fieldName, autoBool: true},
+			false,
+		},
+		{
+			`hcle:"section:Networking"`,
+			fieldMeta{name: fieldName, section: "Networking"},
+			false,
+		},
+		{
+			`hcle:"sensitive"`,
+			fieldMeta{name: fieldName, sensitive: true},
+			false,
+		},
+		{
+			`hcle:"stringer"`,
+			fieldMeta{name: fieldName, stringer: true},
+			false,
+		},
+		{
+			`hcle:"concat"`,
+			fieldMeta{name: fieldName, concat: true},
+			false,
+		},
+		{
+			`hcle:"inheritlabels"`,
+			fieldMeta{name: fieldName, inheritLabels: true},
+			false,
+		},
+		{
+			`hcl:"bar,set"`,
+			fieldMeta{name: "bar", set: true},
+			false,
+		},
+		{
+			`hcl:"bar,object"`,
+			fieldMeta{name: "bar", object: true},
+			false,
+		},
+		{
+			`hcl:"bar,file=bar.hcl"`,
+			fieldMeta{name: "bar", file: "bar.hcl"},
+			false,
+		},
+		{
+			`hcle:"precision:-1"`,
+			fieldMeta{},
+			true,
 		},
 	}
 
@@ -553,7 +1892,13 @@ func TestExtractFieldMeta(t *testing.T) {
 			Name: fieldName,
 			Tag:  reflect.StructTag(test.Tag),
 		}
-		is.EqualValues(test.Expected, extractFieldMeta(input))
+		meta, err := new(Encoder).extractFieldMeta(input)
+		if test.Error {
+			is.Error(err, test.Tag)
+			continue
+		}
+		is.NoError(err, test.Tag)
+		is.EqualValues(test.Expected, meta, test.Tag)
 	}
 
 	input := reflect.StructField{
@@ -564,7 +1909,39 @@ func TestExtractFieldMeta(t *testing.T) {
 		name:      input.Type.Name(),
 		anonymous: true,
 	}
-	is.EqualValues(expected, extractFieldMeta(input))
+	meta, err := new(Encoder).extractFieldMeta(input)
+	is.NoError(err)
+	is.EqualValues(expected, meta)
+}
+
+func TestExtractFieldMeta_CustomTagNames(t *testing.T) {
+	input := reflect.StructField{
+		Name: "Foo",
+		Tag:  `hcl:"ignored" hcle:"omit" hclenc:"bar,key" hclence:"unit:Mi"`,
+	}
+
+	e := &Encoder{TagName: "hclenc", MetaTagName: "hclence"}
+	meta, err := e.extractFieldMeta(input)
+	assert.NoError(t, err)
+	assert.EqualValues(t, fieldMeta{name: "bar", key: true, unit: "Mi"}, meta)
+}
+
+func TestFieldMetas(t *testing.T) {
+	typ := reflect.TypeOf(KeyStruct{})
+
+	metas, err := new(Encoder).fieldMetas(typ)
+	assert.NoError(t, err)
+	assert.Equal(t, []fieldMeta{{name: "Bar", key: true}}, metas)
+
+	cached, _ := new(Encoder).fieldMetas(typ)
+	assert.True(t, &metas[0] == &cached[0], "metas should be cached")
+
+	renamed, err := (&Encoder{TagName: "other"}).fieldMetas(typ)
+	assert.NoError(t, err)
+	assert.Equal(t, []fieldMeta{{name: "Bar"}}, renamed)
+
+	_, err = new(Encoder).fieldMetas(reflect.TypeOf(InvalidPrecisionStruct{}))
+	assert.Error(t, err)
 }
 
 func TestDeref(t *testing.T) {
@@ -678,6 +2055,12 @@ type KeyStruct struct {
 
 func (KeyStruct) Foo() {}
 
+type TrailingKeyStruct struct {
+	Foo  string `hcl:"foo"`
+	Baz  int    `hcl:"baz"`
+	Name string `hcl:",key"`
+}
+
 type KeyChildStruct struct {
 	Foo KeyStruct
 }
@@ -691,10 +2074,55 @@ type OmitStruct struct {
 	Bar string `hcle:"omit"`
 }
 
+type DashOmitStruct struct {
+	Bar string `hcl:"-"`
+}
+
 type OmitEmptyStruct struct {
 	Bar string `hcle:"omitempty"`
 }
 
+type OmitEmptyCollectionStruct struct {
+	List []string          `hcle:"omitempty"`
+	Map  map[string]string `hcle:"omitempty"`
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+type ZonedTimeStruct struct {
+	At time.Time `hcl:"at" hcle:"withzone"`
+}
+
+type KeepNilStruct struct {
+	Ptr     *string  `hcle:"keepnil"`
+	List    []string `hcle:"keepnil"`
+	Omitted *string  `hcle:"keepnil,omitempty"`
+}
+
+type MapHolder struct {
+	Tags map[string]string
+}
+
+type OmitEmptyNestedStruct struct {
+	Inner MapHolder   `hcle:"omitempty"`
+	Ptr   *TestStruct `hcle:"omitempty"`
+	Iface interface{} `hcle:"omitempty"`
+}
+
 type InvalidStruct struct {
 	Chan chan struct{}
 }
@@ -703,18 +2131,229 @@ type InvalidKeyStruct struct {
 	Bar int `hcl:",key"`
 }
 
+type KeywordStruct struct {
+	For string `hcl:"for"`
+}
+
+type NonIdentStruct struct {
+	Dash  string `hcl:"foo-bar"`
+	Dot   string `hcl:"foo.bar"`
+	Digit string `hcl:"1foo"`
+	Space string `hcl:"foo bar"`
+}
+
+type ExprStruct struct {
+	Count string            `hcl:",expr"`
+	Env   map[string]string `hcl:",expr"`
+}
+
+type LiteralStruct struct {
+	Template string            `hcl:"template" hcle:"literal"`
+	Partial  string            `hcl:"partial" hcle:"literal"`
+	Plain    string            `hcl:"plain"`
+	Env      map[string]string `hcl:"env" hcle:"literal"`
+}
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+)
+
+func (c Color) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+type StringerStruct struct {
+	Color  Color   `hcl:"color" hcle:"stringer"`
+	Colors []Color `hcl:"colors" hcle:"stringer"`
+	Count  Color   `hcl:"count"`
+}
+
+type AutoBoolStruct struct {
+	Enabled string   `hcl:"enabled" hcle:"autobool"`
+	Debug   string   `hcl:"debug" hcle:"autobool"`
+	Mode    string   `hcl:"mode" hcle:"autobool"`
+	Flags   []string `hcl:"flags" hcle:"autobool"`
+}
+
+type ArrayStruct struct {
+	Coords [3]int  `hcl:"coords"`
+	ID     [4]byte `hcl:"id" hcle:"base64"`
+}
+
+type RawExpressionStruct struct {
+	Count RawExpression            `hcl:"count" hcle:"unit:Mi"`
+	Zones []RawExpression          `hcl:"zones" hcle:"precision:2"`
+	Env   map[string]RawExpression `hcl:"env"`
+}
+
+type UnitStruct struct {
+	Memory int   `hcle:"unit:Mi"`
+	Sizes  []int `hcle:"unit:Gi"`
+}
+
+type PrecisionStruct struct {
+	Cost      float64   `hcle:"precision:2"`
+	Latencies []float64 `hcle:"precision:1"`
+	Memory    float64   `hcle:"precision:2,unit:Gi"`
+}
+
+type RatStruct struct {
+	Fraction *big.Rat
+	Decimal  big.Rat `hcle:"precision:3"`
+	Whole    *big.Rat
+}
+
+type BigStruct struct {
+	Int      *big.Int
+	Negative big.Int `hcle:"omitempty"`
+	Float    *big.Float
+	Rounded  *big.Float `hcle:"precision:2"`
+	List     []*big.Int
+}
+
+func mustParseBigInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big.Int: " + s)
+	}
+	return i
+}
+
+func mustParseBigFloat(s string) *big.Float {
+	f, _, err := big.ParseFloat(s, 10, 256, big.ToNearestEven)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+type SQLNullStruct struct {
+	Name   sql.NullString   `hcl:"name"`
+	Age    sql.NullInt64    `hcl:"age" hcle:"omitempty"`
+	Active sql.NullBool     `hcl:"active" hcle:"keepnil"`
+	Score  *sql.NullFloat64 `hcl:"score"`
+	Tags   []sql.NullString `hcl:"tags" hcle:"omitempty"`
+}
+
+type Blob []byte
+
+type BytesStruct struct {
+	Raw     []byte
+	Encoded []byte  `hcle:"base64"`
+	Array   [2]byte `hcle:"base64"`
+	Named   Blob
+	List    [][]byte `hcle:"base64"`
+}
+
+type FormatDateStruct struct {
+	Expires time.Time `hcl:"expires" hcle:"formatdate:DD MMM YYYY"`
+	Plain   time.Time `hcl:"plain"`
+}
+
+type InvalidPrecisionStruct struct {
+	Cost float64 `hcle:"precision:x"`
+}
+
+type InvalidUnitStruct struct {
+	Memory string `hcle:"unit:Mi"`
+}
+
 type NillableStruct struct {
 	Bar *string
 }
 
+type NillableCollectionStruct struct {
+	List []string
+	Map  map[string]string
+}
+
 type SquashStruct struct {
 	TestStruct `hcl:",squash"`
 }
 
+type ToggleStruct struct {
+	Enabled bool `hcl:"enabled"`
+	Debug   bool `hcl:"debug"`
+}
+
+type PresenceStruct struct {
+	Feature bool `hcl:"feature" hcle:"presence"`
+}
+
+type SetStruct struct {
+	Tags  map[string]struct{} `hcl:"tags,set"`
+	Ports map[int]struct{}    `hcl:"ports,set"`
+}
+
+type InvalidSetStruct struct {
+	Tags map[string]bool `hcl:"tags,set"`
+}
+
+type ConvertStruct struct {
+	Zones []string `hcl:"zones" hcle:"toset"`
+	Ports []int    `hcl:"ports" hcle:"tolist"`
+}
+
+type Pair struct {
+	Key   string
+	Value string
+}
+
+type PairsStruct struct {
+	Tags []Pair `hcl:"tags,object"`
+}
+
+type ConcatStruct struct {
+	Zones [][]string    `hcl:"zones" hcle:"concat"`
+	Ports []interface{} `hcl:"ports" hcle:"concat,toset"`
+}
+
+type ConvertSetStruct struct {
+	Tags map[string]struct{} `hcl:"tags,set" hcle:"toset"`
+}
+
+type InvalidConvertStruct struct {
+	Items []TestStruct `hcl:"items" hcle:"toset"`
+}
+
+type InvalidPresenceStruct struct {
+	Feature string `hcl:"feature" hcle:"presence"`
+}
+
+type SquashPtrStruct struct {
+	*TestStruct `hcl:",squash"`
+	Baz         string
+}
+
 type SquashKeyChildStruct struct {
 	KeyChildStruct `hcl:",squash"`
 }
 
+type TextStruct struct {
+	Val string
+}
+
+func (t TextStruct) MarshalText() ([]byte, error) {
+	return []byte("text:" + t.Val), nil
+}
+
+type PtrTextStruct struct {
+	Val string
+}
+
+func (t *PtrTextStruct) MarshalText() ([]byte, error) {
+	return []byte("ptr:" + t.Val), nil
+}
+
+type ErrTextStruct struct{}
+
+func (ErrTextStruct) MarshalText() ([]byte, error) {
+	return nil, errors.New("boom")
+}
+
 func strAddr(s string) *string {
 	return &s
 }
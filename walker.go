@@ -1,8 +1,10 @@
 package hclencoder
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/hashicorp/hcl/hcl/ast"
@@ -37,29 +39,42 @@ var startingCursor = cursor{
 	Column: 1,
 }
 
-func positionNodes(node ast.Node, cur cursor, step int) (cursor, error) {
+// positionNodes assigns positions to node and its children so that the printer
+// lays them out with step spaces of indentation. Lists of more than one
+// element are split across lines, unless they hold at most inline literals.
+func positionNodes(node ast.Node, cur cursor, step, inline int) (cursor, error) {
 	var err error
 
 	switch node := node.(type) {
 	case *ast.LiteralType:
 		node.Token.Pos = cur.pos()
+		if node.Token.Type == token.HEREDOC {
+			// the heredoc ends with its anchor on a line of its own
+			cur.Line += strings.Count(node.Token.Text, "\n") - 1
+			cur.Column = 1
+		}
 		cur.Column += utf8.RuneCountInString(node.Token.Text)
 		return cur, nil
 
 	case *ast.ListType:
 		node.Lbrack = cur.pos()
-		if len(node.List) > 1 {
+		multiline := len(node.List) > 1 && !isInlineList(node, inline)
+		if multiline {
 			cur = cur.crlf().in(step)
 		}
-		for _, item := range node.List {
-			if cur, err = positionNodes(item, cur, step); err != nil {
+		for i, item := range node.List {
+			if cur, err = positionNodes(item, cur, step, inline); err != nil {
 				return cur, err
 			}
-			if len(node.List) > 1 {
+			if multiline {
 				cur = cur.crlf()
+			} else if i < len(node.List)-1 {
+				cur.Column += 2
 			}
 		}
-		cur = cur.out(step)
+		if multiline {
+			cur = cur.out(step)
+		}
 		node.Rbrack = cur.pos()
 		cur.Column++
 		return cur, nil
@@ -75,11 +90,11 @@ func positionNodes(node ast.Node, cur cursor, step int) (cursor, error) {
 		}
 		cur.Column += 2
 
-		return positionNodes(node.Val, cur, step)
+		return positionNodes(node.Val, cur, step, inline)
 
 	case *ast.ObjectList:
 		for _, item := range node.Items {
-			cur, err = positionNodes(item, cur, step)
+			cur, err = positionNodes(item, cur, step, inline)
 			if err != nil {
 				return cur, err
 			}
@@ -91,7 +106,7 @@ func positionNodes(node ast.Node, cur cursor, step int) (cursor, error) {
 		node.Lbrace = cur.pos()
 		cur = cur.crlf().in(step)
 
-		if cur, err = positionNodes(node.List, cur, step); err != nil {
+		if cur, err = positionNodes(node.List, cur, step, inline); err != nil {
 			return cur, err
 		}
 		cur = cur.out(step)
@@ -100,9 +115,164 @@ func positionNodes(node ast.Node, cur cursor, step int) (cursor, error) {
 		return cur, nil
 
 	case *ast.File:
-		return positionNodes(node.Node, cur, step)
+		return positionNodes(node.Node, cur, step, inline)
 
 	default:
 		return cur, fmt.Errorf("unknown node kind %s", reflect.ValueOf(node).Kind())
 	}
 }
+
+// isInlineList returns true if list holds at most max elements, all of which
+// are literals without comments.
+func isInlineList(list *ast.ListType, max int) bool {
+	if len(list.List) > max {
+		return false
+	}
+	for _, elem := range list.List {
+		lit, ok := elem.(*ast.LiteralType)
+		if !ok || lit.LeadComment != nil || lit.LineComment != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// visitBlocks calls fn for each block (an item with an object value) within
+// node, parents before their children. The path of each block is prefixed
+// with the path of its parent.
+func visitBlocks(node ast.Node, path string, fn func(string, *ast.ObjectItem)) {
+	switch node := node.(type) {
+	case *ast.ObjectList:
+		for _, item := range node.Items {
+			if _, ok := item.Val.(*ast.ObjectType); !ok {
+				continue
+			}
+			p := blockPath(path, item.Keys)
+			fn(p, item)
+			visitBlocks(item.Val, p, fn)
+		}
+
+	case *ast.ObjectType:
+		visitBlocks(node.List, path, fn)
+	}
+}
+
+// blockPath appends the unquoted text of keys to path, separated by dots.
+func blockPath(path string, keys []*ast.ObjectKey) string {
+	parts := make([]string, 0, len(keys)+1)
+	if path != "" {
+		parts = append(parts, path)
+	}
+	for _, key := range keys {
+		parts = append(parts, keyName(key))
+	}
+	return strings.Join(parts, ".")
+}
+
+// keyName returns the unquoted text of key.
+func keyName(key *ast.ObjectKey) string {
+	if key.Token.Type == token.STRING {
+		if s, ok := key.Token.Value().(string); ok {
+			return s
+		}
+	}
+	return key.Token.Text
+}
+
+// printRaw writes node to b without any formatting. Each item and comment is
+// written on its own line, and lists are written inline unless an element
+// carries a comment or is a heredoc.
+func printRaw(b *bytes.Buffer, node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LiteralType:
+		printComments(b, node.LeadComment)
+		b.WriteString(strings.TrimSuffix(node.Token.Text, "\n"))
+
+	case *ast.ListType:
+		multiline := false
+		for _, elem := range node.List {
+			if lit, ok := elem.(*ast.LiteralType); ok && (lit.LeadComment != nil || lit.LineComment != nil || lit.Token.Type == token.HEREDOC) {
+				multiline = true
+			}
+		}
+
+		b.WriteByte('[')
+		for i, elem := range node.List {
+			if multiline {
+				b.WriteByte('\n')
+			} else if i > 0 {
+				b.WriteString(", ")
+			}
+			printRaw(b, elem)
+			if multiline {
+				if lit, ok := elem.(*ast.LiteralType); ok && lit.Token.Type == token.HEREDOC {
+					// the anchor of a heredoc must be alone on its line
+					b.WriteByte('\n')
+				}
+				b.WriteByte(',')
+				if lit, ok := elem.(*ast.LiteralType); ok {
+					printLineComment(b, lit.LineComment)
+				}
+			}
+		}
+		if multiline {
+			b.WriteByte('\n')
+		}
+		b.WriteByte(']')
+
+	case *ast.ObjectItem:
+		printComments(b, node.LeadComment)
+		for i, key := range node.Keys {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(key.Token.Text)
+		}
+		if _, ok := node.Val.(*ast.ObjectType); ok {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(" = ")
+		}
+		printRaw(b, node.Val)
+		printLineComment(b, node.LineComment)
+
+	case *ast.ObjectList:
+		for i, item := range node.Items {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			printRaw(b, item)
+		}
+
+	case *ast.ObjectType:
+		b.WriteByte('{')
+		if len(node.List.Items) > 0 {
+			b.WriteByte('\n')
+			printRaw(b, node.List)
+			b.WriteByte('\n')
+		}
+		b.WriteByte('}')
+	}
+}
+
+// printComments writes each comment in c on its own line.
+func printComments(b *bytes.Buffer, c *ast.CommentGroup) {
+	if c == nil {
+		return
+	}
+	for _, comment := range c.List {
+		b.WriteString(comment.Text)
+		b.WriteByte('\n')
+	}
+}
+
+// printLineComment writes the comments in c at the end of the current line.
+func printLineComment(b *bytes.Buffer, c *ast.CommentGroup) {
+	if c == nil {
+		return
+	}
+	for _, comment := range c.List {
+		b.WriteByte(' ')
+		b.WriteString(comment.Text)
+	}
+}